@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// AdminHandler serves the /admin/ API, which lets tests inspect and set up
+// the live model directly instead of through the product RPCs:
+//
+//	GET    /admin/model                  the model, as a JSON fixture
+//	PUT    /admin/model                  replace the model with a fixture
+//	PATCH  /admin/model                  merge a partial fixture into the model
+//	DELETE /admin/model/projects/{name}  remove a project
+//	DELETE /admin/model/unclaimed/{dir}  remove an unclaimed dir
+//	DELETE /admin/model/metadata/{id}    remove a catalog entry
+//	POST   /admin/reset                  reload the fixture file
+//	POST   /admin/mappings/reset         restart every mapping's sequence
+//	GET    /admin/sessions               list live sessions
+//	DELETE /admin/sessions/{id}          discard a session
+//	GET    /admin/clock                  the stub's time, and whether it is frozen
+//	POST   /admin/clock/freeze           stop the clock
+//	POST   /admin/clock/advance?by=30s   move the clock forward
+//	POST   /admin/clock/resume           start the clock again
+//	GET    /admin/faults                 the fault injection rules
+//	PUT    /admin/faults                 replace the fault injection rules
+//	DELETE /admin/faults                 remove all fault injection rules
+//	GET    /admin/journal?procedure=P    the recorded calls, optionally to one procedure
+//	POST   /admin/journal/find           the recorded calls that match a pattern
+//	POST   /admin/journal/count          how many recorded calls match a pattern
+//	DELETE /admin/journal                forget the recorded calls
+//	GET    /admin/replay                 how many recorded calls are left to replay
+//	POST   /admin/replay/reset           start the replay over
+//
+// Model request bodies use the fixture file format; see ParseFixture.  Fault
+// request bodies use the fault file format; see ParseFaults.  Journal patterns
+// use the format of ParseRequestPattern.  The model and mapping endpoints act
+// on the session named by SessionHeader, or on the base model if the header is
+// absent; the journal endpoints only see the calls made in that session, or
+// every call if it is absent.  The clock is shared by all sessions.
+type AdminHandler struct {
+	store   *ModelStore
+	fixture string
+	faults  *FaultInterceptor
+	journal *Journal
+	replay  *Replay
+	mux     *http.ServeMux
+}
+
+// NewAdminHandler returns an AdminHandler that manages the models in store,
+// the rules of faults, the calls in journal and replay, which is nil if the
+// stub is not replaying a recording.  Resets reload the fixture file at path
+// fixture, or restore the built-in data if fixture is empty.
+func NewAdminHandler(store *ModelStore, fixture string, faults *FaultInterceptor, journal *Journal, replay *Replay) *AdminHandler {
+	h := &AdminHandler{
+		store:   store,
+		fixture: fixture,
+		faults:  faults,
+		journal: journal,
+		replay:  replay,
+		mux:     http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /admin/model", h.getModel)
+	h.mux.HandleFunc("PUT /admin/model", h.putModel)
+	h.mux.HandleFunc("PATCH /admin/model", h.patchModel)
+	h.mux.HandleFunc("DELETE /admin/model/projects/{name}", h.deleteProject)
+	h.mux.HandleFunc("DELETE /admin/model/unclaimed/{dir}", h.deleteUnclaimed)
+	h.mux.HandleFunc("DELETE /admin/model/metadata/{id}", h.deleteMetadata)
+	h.mux.HandleFunc("POST /admin/reset", h.reset)
+	h.mux.HandleFunc("POST /admin/mappings/reset", h.resetMappings)
+	h.mux.HandleFunc("GET /admin/sessions", h.listSessions)
+	h.mux.HandleFunc("DELETE /admin/sessions/{id}", h.deleteSession)
+	h.mux.HandleFunc("GET /admin/clock", h.getClock)
+	h.mux.HandleFunc("POST /admin/clock/freeze", h.freezeClock)
+	h.mux.HandleFunc("POST /admin/clock/advance", h.advanceClock)
+	h.mux.HandleFunc("POST /admin/clock/resume", h.resumeClock)
+	h.mux.HandleFunc("GET /admin/faults", h.getFaults)
+	h.mux.HandleFunc("PUT /admin/faults", h.putFaults)
+	h.mux.HandleFunc("DELETE /admin/faults", h.deleteFaults)
+	h.mux.HandleFunc("GET /admin/journal", h.getJournal)
+	h.mux.HandleFunc("POST /admin/journal/find", h.findJournal)
+	h.mux.HandleFunc("POST /admin/journal/count", h.countJournal)
+	h.mux.HandleFunc("DELETE /admin/journal", h.deleteJournal)
+	h.mux.HandleFunc("GET /admin/replay", h.getReplay)
+	h.mux.HandleFunc("POST /admin/replay/reset", h.resetReplay)
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// model returns the model selected by r's SessionHeader.
+func (h *AdminHandler) model(r *http.Request) *Model {
+	return h.store.Session(r.Header.Get(SessionHeader))
+}
+
+func (h *AdminHandler) getModel(w http.ResponseWriter, r *http.Request) {
+	// Looking at a session must not start one, with its thumbnail simulator.
+	b, err := h.store.Peek(r.Header.Get(SessionHeader)).MarshalFixture()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func (h *AdminHandler) putModel(w http.ResponseWriter, r *http.Request) {
+	m, err := readFixture(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.store.Replace(r.Header.Get(SessionHeader), m)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) patchModel(w http.ResponseWriter, r *http.Request) {
+	patch, err := readFixture(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.model(r).Merge(patch)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) deleteProject(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	writeRemoved(w, h.model(r).RemoveProject(name), "project not found: %s", name)
+}
+
+func (h *AdminHandler) deleteUnclaimed(w http.ResponseWriter, r *http.Request) {
+	dir := r.PathValue("dir")
+	writeRemoved(w, h.model(r).RemoveUnclaimed(dir), "dir not found: %s", dir)
+}
+
+func (h *AdminHandler) deleteMetadata(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	writeRemoved(w, h.model(r).RemoveMetadata(id), "movie not found: %s", id)
+}
+
+func (h *AdminHandler) reset(w http.ResponseWriter, r *http.Request) {
+	m := data.Clone()
+	if h.fixture != "" {
+		var err error
+		if m, err = LoadFixture(h.fixture); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	h.store.Replace(r.Header.Get(SessionHeader), m)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) resetMappings(w http.ResponseWriter, r *http.Request) {
+	h.model(r).ResetSeqs()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) listSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.store.SessionIDs())
+}
+
+func (h *AdminHandler) deleteSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	writeRemoved(w, h.store.Discard(id), "session not found: %s", id)
+}
+
+func (h *AdminHandler) getClock(w http.ResponseWriter, r *http.Request) {
+	clock := h.store.Clock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Now    time.Time `json:"now"`
+		Frozen bool      `json:"frozen"`
+	}{clock.Now(), clock.Frozen()})
+}
+
+func (h *AdminHandler) freezeClock(w http.ResponseWriter, r *http.Request) {
+	h.store.Clock().Freeze()
+	h.getClock(w, r)
+}
+
+// advanceClock moves the clock forward and applies every transition that has
+// become due before responding, so the caller sees the result right away.
+func (h *AdminHandler) advanceClock(w http.ResponseWriter, r *http.Request) {
+	d, err := time.ParseDuration(r.URL.Query().Get("by"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("by: %v", err), http.StatusBadRequest)
+		return
+	}
+	if d < 0 {
+		http.Error(w, "by: the clock cannot go backwards", http.StatusBadRequest)
+		return
+	}
+	h.store.Clock().Advance(d)
+	h.store.Tick()
+	h.getClock(w, r)
+}
+
+func (h *AdminHandler) resumeClock(w http.ResponseWriter, r *http.Request) {
+	h.store.Clock().Resume()
+	h.getClock(w, r)
+}
+
+func (h *AdminHandler) getFaults(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.faults.Rules())
+}
+
+func (h *AdminHandler) putFaults(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rules, err := ParseFaults(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.faults.SetRules(rules)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) deleteFaults(w http.ResponseWriter, r *http.Request) {
+	h.faults.SetRules(nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) getJournal(w http.ResponseWriter, r *http.Request) {
+	var pattern *RequestResponseMapping
+	if procedure := r.URL.Query().Get("procedure"); procedure != "" {
+		if _, err := findMethod(procedure); err != nil {
+			http.Error(w, fmt.Sprintf("procedure: %v", err), http.StatusBadRequest)
+			return
+		}
+		pattern = &RequestResponseMapping{Procedure: procedure}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.journal.Find(r.Header.Get(SessionHeader), pattern))
+}
+
+func (h *AdminHandler) findJournal(w http.ResponseWriter, r *http.Request) {
+	pattern, err := readRequestPattern(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.journal.Find(r.Header.Get(SessionHeader), pattern))
+}
+
+func (h *AdminHandler) countJournal(w http.ResponseWriter, r *http.Request) {
+	pattern, err := readRequestPattern(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Count int `json:"count"`
+	}{len(h.journal.Find(r.Header.Get(SessionHeader), pattern))})
+}
+
+func (h *AdminHandler) deleteJournal(w http.ResponseWriter, r *http.Request) {
+	h.journal.Clear(r.Header.Get(SessionHeader))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) getReplay(w http.ResponseWriter, r *http.Request) {
+	if h.replay == nil {
+		http.Error(w, "not replaying a recording", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Remaining int `json:"remaining"`
+	}{h.replay.Remaining()})
+}
+
+func (h *AdminHandler) resetReplay(w http.ResponseWriter, r *http.Request) {
+	if h.replay == nil {
+		http.Error(w, "not replaying a recording", http.StatusNotFound)
+		return
+	}
+	h.replay.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func readRequestPattern(r *http.Request) (*RequestResponseMapping, error) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRequestPattern(b)
+}
+
+func readFixture(r *http.Request) (*Model, error) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("request body must be a fixture")
+	}
+	return ParseFixture(b)
+}
+
+func writeRemoved(w http.ResponseWriter, removed bool, format string, args ...any) {
+	if !removed {
+		http.Error(w, fmt.Sprintf(format, args...), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
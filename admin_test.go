@@ -0,0 +1,209 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"connectrpc.com/connect"
+)
+
+func TestAdminHandler(t *testing.T) {
+	store := NewModelStore(data.Clone(), nil)
+	server := httptest.NewServer(NewAdminHandler(store, "", &FaultInterceptor{}, NewJournal(store.Clock(), 100), nil))
+	defer server.Close()
+
+	do := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	// GET returns the model in a form PUT accepts.
+	status, body := do("GET", "/admin/model", "")
+	if status != http.StatusOK || !strings.Contains(body, `"Name With Spaces"`) {
+		t.Fatalf("Expected the built-in model, got %d: %s", status, body)
+	}
+	if status, _ := do("PUT", "/admin/model", body); status != http.StatusNoContent {
+		t.Fatalf("Expected PUT of GET output to succeed, got %d", status)
+	}
+
+	// PUT replaces the whole model.
+	status, body = do("PUT", "/admin/model", `{"projects": [{"project": "Replaced"}], "unclaimed": ["Dir"]}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("Expected PUT to succeed, got %d: %s", status, body)
+	}
+	if got, want := store.Base().ProjectNames(), []string{"Replaced"}; !slices.Equal(got, want) {
+		t.Errorf("Expected projects %v, got %v", want, got)
+	}
+
+	// PATCH merges into the model.
+	status, body = do("PATCH", "/admin/model", `{"projects": [{"project": "Replaced", "discs": [{"disc": "Disc"}]}, {"project": "Added"}], "unclaimed": ["Other Dir"]}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("Expected PATCH to succeed, got %d: %s", status, body)
+	}
+	if got, want := store.Base().ProjectNames(), []string{"Replaced", "Added"}; !slices.Equal(got, want) {
+		t.Errorf("Expected projects %v, got %v", want, got)
+	}
+	if p := store.Base().GetProject("Replaced"); len(p.Discs) != 1 {
+		t.Errorf("Expected patched project to have one disc, got %v", p.Discs)
+	}
+	if got, want := store.Base().UnclaimedDirs(), []string{"Dir", "Other Dir"}; !slices.Equal(got, want) {
+		t.Errorf("Expected unclaimed %v, got %v", want, got)
+	}
+
+	// DELETE removes individual entries.
+	if status, _ := do("DELETE", "/admin/model/unclaimed/Other%20Dir", ""); status != http.StatusNoContent {
+		t.Errorf("Expected DELETE of unclaimed dir to succeed, got %d", status)
+	}
+	if status, _ := do("DELETE", "/admin/model/projects/Added", ""); status != http.StatusNoContent {
+		t.Errorf("Expected DELETE of project to succeed, got %d", status)
+	}
+	if status, _ := do("DELETE", "/admin/model/projects/Added", ""); status != http.StatusNotFound {
+		t.Errorf("Expected DELETE of missing project to fail, got %d", status)
+	}
+
+	// Bad fixtures are rejected with the location of the problem.
+	status, body = do("PUT", "/admin/model", "projects:\n  - projct: A\n")
+	if status != http.StatusBadRequest || !strings.Contains(body, "line 2") {
+		t.Errorf("Expected a bad request naming line 2, got %d: %s", status, body)
+	}
+
+	// Reset restores the built-in data.
+	if status, _ := do("POST", "/admin/reset", ""); status != http.StatusNoContent {
+		t.Fatalf("Expected reset to succeed, got %d", status)
+	}
+	if got, want := store.Base().ProjectNames(), []string{"Empty", "Name With Spaces"}; !slices.Equal(got, want) {
+		t.Errorf("Expected projects %v, got %v", want, got)
+	}
+}
+
+func TestAdminClock(t *testing.T) {
+	base := &Model{
+		Projects:  []*v1.ProjectGetResponse{{Project: "P"}},
+		Unclaimed: []string{"Disc"},
+	}
+	store := NewModelStore(base, &ThumbConfig{WaitDelay: 10 * time.Second, WorkDelay: 20 * time.Second})
+	defer store.Close()
+	server := httptest.NewServer(NewAdminHandler(store, "", &FaultInterceptor{}, NewJournal(store.Clock(), 100), nil))
+	defer server.Close()
+
+	post := func(path string) string {
+		t.Helper()
+		resp, err := http.Post(server.URL+path, "", nil)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected POST %s to succeed, got %d: %s", path, resp.StatusCode, b)
+		}
+		return string(b)
+	}
+	state := func() string {
+		t.Helper()
+		return store.Base().GetProject("P").Discs[0].ThumbState
+	}
+
+	if body := post("/admin/clock/freeze"); !strings.Contains(body, `"frozen":true`) {
+		t.Fatalf("Expected a frozen clock, got %s", body)
+	}
+	frozen := store.Clock().Now()
+	if err := store.Base().AssignDiscDirs("P", []string{"Disc"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	post("/admin/clock/advance?by=9s")
+	if got := state(); got != ThumbStateWaiting {
+		t.Errorf("Expected %s after 9s, got %s", ThumbStateWaiting, got)
+	}
+	post("/admin/clock/advance?by=1s")
+	if got := state(); got != ThumbStateWorking {
+		t.Errorf("Expected %s after 10s, got %s", ThumbStateWorking, got)
+	}
+	post("/admin/clock/advance?by=20s")
+	if got := state(); got != ThumbStateDone {
+		t.Errorf("Expected %s after 30s, got %s", ThumbStateDone, got)
+	}
+	if got, want := store.Clock().Now(), frozen.Add(30*time.Second); !got.Equal(want) {
+		t.Errorf("Expected the clock to show %v, got %v", want, got)
+	}
+
+	for _, by := range []string{"", "soon", "-1s"} {
+		resp, err := http.Post(server.URL+"/admin/clock/advance?by="+by, "", nil)
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected advancing by %q to be rejected, got %d", by, resp.StatusCode)
+		}
+	}
+
+	if body := post("/admin/clock/resume"); !strings.Contains(body, `"frozen":false`) {
+		t.Errorf("Expected a running clock, got %s", body)
+	}
+}
+
+func TestAdminFaults(t *testing.T) {
+	faults := &FaultInterceptor{}
+	store := NewModelStore(data.Clone(), nil)
+	server := httptest.NewServer(NewAdminHandler(store, "", faults, NewJournal(store.Clock(), 100), nil))
+	defer server.Close()
+
+	do := func(method, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+"/admin/faults", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s failed: %v", method, err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	if status, body := do("PUT", `[{"procedure": "*", "latency": "10ms", "code": "unavailable", "nth": 2}]`); status != http.StatusNoContent {
+		t.Fatalf("Expected PUT to succeed, got %d: %s", status, body)
+	}
+	if got := faults.Rules(); len(got) != 1 || got[0].Code != connect.CodeUnavailable {
+		t.Errorf("Expected the rule to be installed, got %+v", got)
+	}
+	status, body := do("GET", "")
+	if want := `[{"procedure":"*","latency":"10ms","code":"unavailable","nth":2}]`; status != http.StatusOK || strings.TrimSpace(body) != want {
+		t.Errorf("Expected %s, got %d: %s", want, status, body)
+	}
+
+	// Invalid rules leave the current ones in place.
+	if status, _ := do("PUT", `[{"procedure": "*", "code": "unavailable", "probability": 3}]`); status != http.StatusBadRequest {
+		t.Errorf("Expected an invalid rule to be rejected, got %d", status)
+	}
+	if got := faults.Rules(); len(got) != 1 {
+		t.Errorf("Expected the previous rule to be kept, got %+v", got)
+	}
+
+	if status, _ := do("DELETE", ""); status != http.StatusNoContent {
+		t.Errorf("Expected DELETE to succeed, got %d", status)
+	}
+	if got := faults.Rules(); len(got) != 0 {
+		t.Errorf("Expected no rules, got %+v", got)
+	}
+}
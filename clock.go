@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time to everything in the stub that depends on it, so that
+// tests can move time forward instead of waiting for it.
+type Clock interface {
+	Now() time.Time
+}
+
+// VirtualClock is a Clock that follows real time until it is frozen, and can
+// be moved forward by hand whether or not it is frozen.  The zero value
+// follows real time.
+type VirtualClock struct {
+	mu     sync.Mutex
+	frozen bool
+	at     time.Time     // the time while frozen
+	offset time.Duration // added to real time while running
+
+	// changed is closed, and replaced, whenever the clock is frozen, moved or
+	// resumed, to wake up Sleep.
+	changed chan struct{}
+}
+
+// Now implements Clock.
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now()
+}
+
+// now returns the current time.  Callers must hold c.mu.
+func (c *VirtualClock) now() time.Time {
+	if c.frozen {
+		return c.at
+	}
+	return time.Now().Add(c.offset)
+}
+
+// change wakes up the callers of Sleep.  Callers must hold c.mu.
+func (c *VirtualClock) change() {
+	if c.changed != nil {
+		close(c.changed)
+		c.changed = nil
+	}
+}
+
+// Sleep waits until the clock has moved forward by d, or ctx is done, in
+// which case it returns ctx.Err().  While the clock is frozen it only moves
+// with Advance.
+func (c *VirtualClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.mu.Lock()
+	deadline := c.now().Add(d)
+	c.mu.Unlock()
+	for {
+		c.mu.Lock()
+		left := deadline.Sub(c.now())
+		if left <= 0 {
+			c.mu.Unlock()
+			return nil
+		}
+		if c.changed == nil {
+			c.changed = make(chan struct{})
+		}
+		changed := c.changed
+		frozen := c.frozen
+		c.mu.Unlock()
+
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !frozen {
+			timer = time.NewTimer(left)
+			timeout = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// Frozen reports whether the clock is frozen.
+func (c *VirtualClock) Frozen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.frozen
+}
+
+// Freeze stops the clock at the current time.
+func (c *VirtualClock) Freeze() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.at = c.now()
+	c.frozen = true
+	c.change()
+}
+
+// Advance moves the clock forward by d.
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frozen {
+		c.at = c.at.Add(d)
+	} else {
+		c.offset += d
+	}
+	c.change()
+}
+
+// Resume starts a frozen clock again from the time it shows, so that it never
+// goes backwards.  Time skipped with Advance stays skipped.
+func (c *VirtualClock) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frozen {
+		c.offset = c.at.Sub(time.Now())
+		c.frozen = false
+		c.change()
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestVirtualClock(t *testing.T) {
+	var c VirtualClock
+	if d := time.Since(c.Now()); d < 0 || d > time.Second {
+		t.Fatalf("Expected the zero clock to follow real time, off by %v", d)
+	}
+
+	c.Freeze()
+	frozen := c.Now()
+	time.Sleep(5 * time.Millisecond)
+	if got := c.Now(); !got.Equal(frozen) {
+		t.Errorf("Expected a frozen clock to stay at %v, got %v", frozen, got)
+	}
+
+	c.Advance(time.Hour)
+	if got, want := c.Now(), frozen.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("Expected %v after advancing, got %v", want, got)
+	}
+
+	// Resuming carries on from the advanced time rather than jumping back.
+	c.Resume()
+	if c.Frozen() {
+		t.Error("Expected the clock to be running")
+	}
+	if got := c.Now(); got.Before(frozen.Add(time.Hour)) {
+		t.Errorf("Expected the clock to keep the hour it was advanced, got %v", got)
+	}
+
+	// Advancing a running clock moves it forward too.
+	before := c.Now()
+	c.Advance(time.Hour)
+	if got := c.Now(); got.Sub(before) < time.Hour {
+		t.Errorf("Expected the clock to move an hour, moved %v", got.Sub(before))
+	}
+}
+
+func TestVirtualClockSleep(t *testing.T) {
+	var c VirtualClock
+	ctx := context.Background()
+
+	// A running clock sleeps in real time.
+	start := time.Now()
+	if err := c.Sleep(ctx, 10*time.Millisecond); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Errorf("Expected to sleep at least 10ms, slept %v", d)
+	}
+
+	// A frozen clock only wakes sleepers when it is advanced far enough.
+	c.Freeze()
+	done := make(chan error)
+	go func() { done <- c.Sleep(ctx, time.Hour) }()
+	for _, d := range []time.Duration{0, 30 * time.Minute} {
+		c.Advance(d)
+		select {
+		case err := <-done:
+			t.Fatalf("Expected the sleep to go on after %v of it, got %v", d, err)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	c.Advance(30 * time.Minute)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the sleep to end once the clock was advanced an hour")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() { done <- c.Sleep(ctx, time.Hour) }()
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected the sleep to be canceled, got %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+// Command contract-diff replays a corpus of requests against the stub and a
+// reference backend, and reports where their responses differ.
+//
+// The corpus is a JSON Lines file in the stub's recording format; only the
+// procedure and request of each line are used.  For example:
+//
+//	contract-diff -reference http://localhost:9090 -corpus calls.jsonl
+//
+// Calls are made in order, first to the stub and then to the reference.  For
+// each call, the two responses are compared field by field, or if either call
+// failed, their error codes are.  The command exits with status 1 if any call
+// differs, and 2 if the comparison could not be made.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	_ "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1" // registers the service
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// call is one request from the corpus.
+type call struct {
+	line   int
+	method protoreflect.MethodDescriptor
+	req    *dynamicpb.Message
+}
+
+// readCorpus reads the calls in a recording.
+func readCorpus(r io.Reader) ([]call, error) {
+	var out []call
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var j struct {
+			Procedure string          `json:"procedure"`
+			Request   json.RawMessage `json:"request"`
+		}
+		if err := json.Unmarshal(b, &j); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		method, err := findMethod(j.Procedure)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: procedure: %w", line, err)
+		}
+		req := dynamicpb.NewMessage(method.Input())
+		if j.Request != nil {
+			if err := protojson.Unmarshal(j.Request, req); err != nil {
+				return nil, fmt.Errorf("line %d: request: %w", line, err)
+			}
+		}
+		out = append(out, call{line: line, method: method, req: req})
+	}
+	return out, scanner.Err()
+}
+
+// findMethod returns the descriptor of a procedure such as
+// "/krelinga.video.in.v1.Service/ProjectGet".
+func findMethod(procedure string) (protoreflect.MethodDescriptor, error) {
+	name := protoreflect.FullName(strings.ReplaceAll(strings.TrimPrefix(procedure, "/"), "/", "."))
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown procedure %q", procedure)
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("unknown procedure %q", procedure)
+	}
+	return md, nil
+}
+
+func procedure(md protoreflect.MethodDescriptor) string {
+	return "/" + string(md.Parent().FullName()) + "/" + string(md.Name())
+}
+
+// initResponse makes the dynamic responses of a client the output type of
+// its method.
+func initResponse(spec connect.Spec, msg any) error {
+	md, ok := spec.Schema.(protoreflect.MethodDescriptor)
+	if !ok {
+		return fmt.Errorf("invalid schema %T", spec.Schema)
+	}
+	*msg.(*dynamicpb.Message) = *dynamicpb.NewMessage(md.Output())
+	return nil
+}
+
+// invoke makes c against the server at baseURL.
+func invoke(ctx context.Context, baseURL string, c call, header http.Header) (*dynamicpb.Message, error) {
+	client := connect.NewClient[dynamicpb.Message, dynamicpb.Message](
+		http.DefaultClient,
+		strings.TrimRight(baseURL, "/")+procedure(c.method),
+		connect.WithSchema(c.method),
+		connect.WithResponseInitializer(initResponse),
+	)
+	req := connect.NewRequest(c.req)
+	for k, vs := range header {
+		req.Header()[k] = vs
+	}
+	resp, err := client.CallUnary(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Msg, nil
+}
+
+// diffMessages returns the differences between stub and ref, one per line,
+// naming each field by its path from prefix.  Fields whose paths are in
+// ignore, without list indexes and map keys, are skipped.
+func diffMessages(stub, ref protoreflect.Message, prefix string, ignore []string) []string {
+	var out []string
+	fields := stub.Descriptor().Fields()
+	for i := range fields.Len() {
+		fd := fields.Get(i)
+		path := prefix + fd.JSONName()
+		if slices.Contains(ignore, indexRE.ReplaceAllString(path, "")) {
+			continue
+		}
+		sv, rv := stub.Get(fd), ref.Get(fd)
+		switch {
+		case fd.IsList():
+			out = append(out, diffLists(fd, sv.List(), rv.List(), path, ignore)...)
+		case fd.IsMap():
+			out = append(out, diffMaps(fd, sv.Map(), rv.Map(), path, ignore)...)
+		case fd.Message() != nil:
+			if stub.Has(fd) != ref.Has(fd) {
+				out = append(out, fmt.Sprintf("%s: stub %s, reference %s", path, presence(stub.Has(fd)), presence(ref.Has(fd))))
+				continue
+			}
+			out = append(out, diffMessages(sv.Message(), rv.Message(), path+".", ignore)...)
+		default:
+			if !sv.Equal(rv) {
+				out = append(out, fmt.Sprintf("%s: stub %s, reference %s", path, formatValue(fd, sv), formatValue(fd, rv)))
+			}
+		}
+	}
+	return out
+}
+
+// indexRE matches the list indexes and map keys in a field path.
+var indexRE = regexp.MustCompile(`\[[^\]]*\]`)
+
+func diffLists(fd protoreflect.FieldDescriptor, stub, ref protoreflect.List, path string, ignore []string) []string {
+	var out []string
+	if stub.Len() != ref.Len() {
+		out = append(out, fmt.Sprintf("%s: stub has %d elements, reference %d", path, stub.Len(), ref.Len()))
+	}
+	for i := range min(stub.Len(), ref.Len()) {
+		elem := fmt.Sprintf("%s[%d]", path, i)
+		sv, rv := stub.Get(i), ref.Get(i)
+		if fd.Message() != nil {
+			out = append(out, diffMessages(sv.Message(), rv.Message(), elem+".", ignore)...)
+		} else if !sv.Equal(rv) {
+			out = append(out, fmt.Sprintf("%s: stub %s, reference %s", elem, formatValue(fd, sv), formatValue(fd, rv)))
+		}
+	}
+	return out
+}
+
+func diffMaps(fd protoreflect.FieldDescriptor, stub, ref protoreflect.Map, path string, ignore []string) []string {
+	var out []string
+	vd := fd.MapValue()
+	stub.Range(func(k protoreflect.MapKey, sv protoreflect.Value) bool {
+		elem := fmt.Sprintf("%s[%v]", path, k.Interface())
+		switch rv := ref.Get(k); {
+		case !ref.Has(k):
+			out = append(out, fmt.Sprintf("%s: stub set, reference unset", elem))
+		case vd.Message() != nil:
+			out = append(out, diffMessages(sv.Message(), rv.Message(), elem+".", ignore)...)
+		case !sv.Equal(rv):
+			out = append(out, fmt.Sprintf("%s: stub %s, reference %s", elem, formatValue(vd, sv), formatValue(vd, rv)))
+		}
+		return true
+	})
+	ref.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+		if !stub.Has(k) {
+			out = append(out, fmt.Sprintf("%s[%v]: stub unset, reference set", path, k.Interface()))
+		}
+		return true
+	})
+	slices.Sort(out)
+	return out
+}
+
+func presence(set bool) string {
+	if set {
+		return "set"
+	}
+	return "unset"
+}
+
+func formatValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return fmt.Sprintf("%q", v.String())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// diffCall returns the differences between the results of one call.
+func diffCall(stub, ref *dynamicpb.Message, stubErr, refErr error, ignore []string) []string {
+	if stubErr != nil || refErr != nil {
+		if outcome(stubErr) == outcome(refErr) {
+			return nil
+		}
+		return []string{fmt.Sprintf("error: stub %s, reference %s", outcome(stubErr), outcome(refErr))}
+	}
+	return diffMessages(stub, ref, "", ignore)
+}
+
+func outcome(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return connect.CodeOf(err).String()
+}
+
+// config holds the command's flags.
+type config struct {
+	stub      string
+	reference string
+	header    http.Header // sent with every call
+	ignore    []string    // field paths not to compare
+}
+
+// run makes every call in corpus against both servers and writes a report of
+// their differences to w.  It returns how many calls differed.
+func run(ctx context.Context, cfg config, calls []call, w io.Writer) (int, error) {
+	type stats struct{ calls, differ int }
+	byProcedure := make(map[string]*stats)
+	var order []string
+	differ := 0
+	for _, c := range calls {
+		p := procedure(c.method)
+		st := byProcedure[p]
+		if st == nil {
+			st = &stats{}
+			byProcedure[p] = st
+			order = append(order, p)
+		}
+		st.calls++
+
+		stub, stubErr := invoke(ctx, cfg.stub, c, cfg.header)
+		ref, refErr := invoke(ctx, cfg.reference, c, cfg.header)
+		for _, err := range []error{stubErr, refErr} {
+			if err != nil && !connect.IsWireError(err) {
+				return differ, fmt.Errorf("line %d: %w", c.line, err)
+			}
+		}
+		diffs := diffCall(stub, ref, stubErr, refErr, cfg.ignore)
+		if len(diffs) == 0 {
+			continue
+		}
+		st.differ++
+		differ++
+		b, _ := protojson.Marshal(c.req)
+		fmt.Fprintf(w, "line %d: %s %s\n", c.line, p, b)
+		for _, d := range diffs {
+			fmt.Fprintf(w, "\t%s\n", d)
+		}
+	}
+	fmt.Fprintln(w)
+	for _, p := range order {
+		st := byProcedure[p]
+		fmt.Fprintf(w, "%s: %d of %d calls differ\n", p, st.differ, st.calls)
+	}
+	return differ, nil
+}
+
+// headerFlag collects repeated -header flags.
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	return fmt.Sprint(http.Header(h))
+}
+
+func (h headerFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	if !ok {
+		return errors.New("want Name: value")
+	}
+	http.Header(h).Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	return nil
+}
+
+func main() {
+	cfg := config{header: http.Header{}}
+	flag.StringVar(&cfg.stub, "stub", "http://localhost:8080", "URL of the stub")
+	flag.StringVar(&cfg.reference, "reference", "", "URL of the reference backend")
+	corpus := flag.String("corpus", "", "JSON Lines recording of the calls to make")
+	ignore := flag.String("ignore", "", "comma-separated field paths not to compare, e.g. discs.thumbState")
+	flag.Var(headerFlag(cfg.header), "header", "header to send with every call, as Name: value; may repeat")
+	flag.Parse()
+	if cfg.reference == "" || *corpus == "" {
+		fmt.Fprintln(os.Stderr, "contract-diff: -reference and -corpus are required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *ignore != "" {
+		cfg.ignore = strings.Split(*ignore, ",")
+	}
+
+	f, err := os.Open(*corpus)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "contract-diff: %v\n", err)
+		os.Exit(2)
+	}
+	calls, err := readCorpus(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "contract-diff: %s: %v\n", *corpus, err)
+		os.Exit(2)
+	}
+
+	differ, err := run(context.Background(), cfg, calls, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "contract-diff: %v\n", err)
+		os.Exit(2)
+	}
+	if differ > 0 {
+		os.Exit(1)
+	}
+}
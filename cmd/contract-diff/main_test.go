@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"buf.build/gen/go/krelinga/proto/connectrpc/go/krelinga/video/in/v1/inv1connect"
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"connectrpc.com/connect"
+)
+
+// fakeBackend answers ProjectGet with one disc in thumbState, ProjectList with
+// projects, and fails ProjectNew with newErr if it is set.
+type fakeBackend struct {
+	inv1connect.UnimplementedServiceHandler
+	thumbState string
+	projects   []string
+	newErr     error
+}
+
+func (b *fakeBackend) ProjectGet(ctx context.Context, req *connect.Request[v1.ProjectGetRequest]) (*connect.Response[v1.ProjectGetResponse], error) {
+	return connect.NewResponse(&v1.ProjectGetResponse{
+		Project: req.Msg.Project,
+		Discs:   []*v1.ProjectDisc{{Disc: "Disc 1", ThumbState: b.thumbState}},
+	}), nil
+}
+
+func (b *fakeBackend) ProjectList(ctx context.Context, req *connect.Request[v1.ProjectListRequest]) (*connect.Response[v1.ProjectListResponse], error) {
+	return connect.NewResponse(&v1.ProjectListResponse{Projects: b.projects}), nil
+}
+
+func (b *fakeBackend) ProjectNew(ctx context.Context, req *connect.Request[v1.ProjectNewRequest]) (*connect.Response[v1.ProjectNewResponse], error) {
+	if b.newErr != nil {
+		return nil, b.newErr
+	}
+	return connect.NewResponse(&v1.ProjectNewResponse{}), nil
+}
+
+func serve(t *testing.T, b *fakeBackend) string {
+	t.Helper()
+	_, handler := inv1connect.NewServiceHandler(b)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+const corpus = `
+{"procedure": "/krelinga.video.in.v1.Service/ProjectGet", "request": {"project": "P"}, "response": {"project": "P"}}
+{"procedure": "/krelinga.video.in.v1.Service/ProjectList", "request": {}}
+{"procedure": "/krelinga.video.in.v1.Service/ProjectNew", "request": {"name": "P"}}
+{"procedure": "/krelinga.video.in.v1.Service/ProjectNew", "request": {"name": "Q"}}
+`
+
+func TestRun(t *testing.T) {
+	calls, err := readCorpus(strings.NewReader(corpus))
+	if err != nil {
+		t.Fatalf("Failed to read corpus: %v", err)
+	}
+	cfg := config{
+		stub:      serve(t, &fakeBackend{thumbState: "done", projects: []string{"P"}}),
+		reference: serve(t, &fakeBackend{thumbState: "waiting", projects: []string{"P"}, newErr: connect.NewError(connect.CodeAlreadyExists, errors.New("exists"))}),
+	}
+
+	var out strings.Builder
+	differ, err := run(context.Background(), cfg, calls, &out)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if differ != 3 {
+		t.Errorf("Expected 3 calls to differ, got %d:\n%s", differ, out.String())
+	}
+	for _, want := range []string{
+		`line 2: /krelinga.video.in.v1.Service/ProjectGet {"project":"P"}`,
+		`discs[0].thumbState: stub "done", reference "waiting"`,
+		"error: stub ok, reference already_exists",
+		"/krelinga.video.in.v1.Service/ProjectList: 0 of 1 calls differ",
+		"/krelinga.video.in.v1.Service/ProjectNew: 2 of 2 calls differ",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected the report to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	cfg.ignore = []string{"discs.thumbState"}
+	if differ, err := run(context.Background(), cfg, calls, &strings.Builder{}); err != nil || differ != 2 {
+		t.Errorf("Expected 2 calls to differ when ignoring thumbState, got %d, %v", differ, err)
+	}
+
+	cfg.reference = "http://127.0.0.1:1"
+	if _, err := run(context.Background(), cfg, calls, &strings.Builder{}); err == nil {
+		t.Error("Expected an error for an unreachable reference")
+	}
+}
+
+func TestReadCorpus(t *testing.T) {
+	for _, tt := range []struct {
+		corpus  string
+		wantErr string
+	}{
+		{corpus: `{"procedure": "/krelinga.video.in.v1.Service/Nope"}`, wantErr: "line 1: procedure"},
+		{corpus: "\n" + `{"procedure": "/krelinga.video.in.v1.Service/ProjectGet", "request": {"name": "x"}}`, wantErr: "line 2: request"},
+		{corpus: `not json`, wantErr: "line 1"},
+	} {
+		if _, err := readCorpus(strings.NewReader(tt.corpus)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Expected an error containing %q for %s, got: %v", tt.wantErr, tt.corpus, err)
+		}
+	}
+}
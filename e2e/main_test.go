@@ -26,6 +26,8 @@ func TestEndToEnd(t *testing.T) {
 				Context:    "..",
 				Dockerfile: "Dockerfile",
 			},
+			// Keep newly assigned discs waiting for thumbnails for the whole run.
+			Cmd:          []string{"./video-in-be-stub", "-thumbs-wait=1h"},
 			ExposedPorts: []string{"8080/tcp"},
 			WaitingFor:   wait.ForListeningPort("8080/tcp").WithStartupTimeout(30 * time.Second),
 		},
@@ -205,70 +207,238 @@ func TestEndToEnd(t *testing.T) {
 		}
 	})
 
-	t.Run("UnimplementedMethods", func(t *testing.T) {
-		calls := []struct {
-			method string
-			call   func() error
-		}{
-			{
-				method: "ProjectNew",
-				call: func() error {
-					req := connect.NewRequest(&v1.ProjectNewRequest{})
-					_, err := client.ProjectNew(ctx, req)
-					return err
-				},
-			},
-			{
-				method: "ProjectAssignDiskDirs",
-				call: func() error {
-					req := connect.NewRequest(&v1.ProjectAssignDiskDirsRequest{})
-					_, err := client.ProjectAssignDiskDirs(ctx, req)
-					return err
-				},
-			},
-			{
-				method: "ProjectCategorizeFiles",
-				call: func() error {
-					req := connect.NewRequest(&v1.ProjectCategorizeFilesRequest{})
-					_, err := client.ProjectCategorizeFiles(ctx, req)
-					return err
-				},
-			},
-			{
-				method: "ProjectSetMetadata",
-				call: func() error {
-					req := connect.NewRequest(&v1.ProjectSetMetadataRequest{})
-					_, err := client.ProjectSetMetadata(ctx, req)
-					return err
-				},
-			},
-			{
-				method: "ProjectFinish",
-				call: func() error {
-					req := connect.NewRequest(&v1.ProjectFinishRequest{})
-					_, err := client.ProjectFinish(ctx, req)
-					return err
-				},
-			},
-			{
-				method: "ProjectAbandon",
-				call: func() error {
-					req := connect.NewRequest(&v1.ProjectAbandonRequest{})
-					_, err := client.ProjectAbandon(ctx, req)
-					return err
-				},
-			},
+	const newProject = "E2E New Project"
+	t.Run("ProjectNew", func(t *testing.T) {
+
+		// Create the project
+		req := connect.NewRequest(&v1.ProjectNewRequest{Name: newProject})
+		if _, err := client.ProjectNew(ctx, req); err != nil {
+			t.Fatalf("ProjectNew call failed: %v", err)
 		}
-		for _, c := range calls {
-			t.Run(c.method, func(t *testing.T) {
-				err := c.call()
-				if err == nil {
-					t.Fatalf("Expected error for unimplemented method '%s', got nil", c.method)
-				}
-				if !strings.Contains(err.Error(), "is not implemented") {
-					t.Fatalf("Expected unimplemented error for method '%s', got: %v", c.method, err)
+
+		// Verify the project is visible through ProjectList
+		listResp, err := client.ProjectList(ctx, connect.NewRequest(&v1.ProjectListRequest{}))
+		if err != nil {
+			t.Fatalf("ProjectList call failed: %v", err)
+		}
+		found := false
+		for _, p := range listResp.Msg.Projects {
+			if p == newProject {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("Expected project '%s' not found in response", newProject)
+		}
+
+		// Verify the project is visible through ProjectGet
+		getResp, err := client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: newProject}))
+		if err != nil {
+			t.Fatalf("ProjectGet call failed: %v", err)
+		}
+		if getResp.Msg.Project != newProject {
+			t.Fatalf("Expected project '%s', got '%s'", newProject, getResp.Msg.Project)
+		}
+
+		// Verify duplicates and invalid names are rejected
+		_, err = client.ProjectNew(ctx, connect.NewRequest(&v1.ProjectNewRequest{Name: newProject}))
+		if connect.CodeOf(err) != connect.CodeAlreadyExists {
+			t.Fatalf("Expected AlreadyExists for duplicate project, got: %v", err)
+		}
+		_, err = client.ProjectNew(ctx, connect.NewRequest(&v1.ProjectNewRequest{}))
+		if connect.CodeOf(err) != connect.CodeInvalidArgument {
+			t.Fatalf("Expected InvalidArgument for empty project name, got: %v", err)
+		}
+	})
+
+	t.Run("ProjectAssignDiskDirs", func(t *testing.T) {
+		const dir = "Unclaimed1"
+
+		// Assign an unclaimed dir to the project created above
+		req := connect.NewRequest(&v1.ProjectAssignDiskDirsRequest{Project: newProject, Dirs: []string{dir}})
+		if _, err := client.ProjectAssignDiskDirs(ctx, req); err != nil {
+			t.Fatalf("ProjectAssignDiskDirs call failed: %v", err)
+		}
+
+		// Verify the dir is no longer unclaimed
+		listResp, err := client.UnclaimedDiscDirList(ctx, connect.NewRequest(&v1.UnclaimedDiscDirListRequest{}))
+		if err != nil {
+			t.Fatalf("UnclaimedDiscDirList call failed: %v", err)
+		}
+		for _, u := range listResp.Msg.Dirs {
+			if u == dir {
+				t.Fatalf("Expected dir '%s' to be claimed, but it is still unclaimed", dir)
+			}
+		}
+
+		// Verify the project has a new disc waiting for thumbnails
+		getResp, err := client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: newProject}))
+		if err != nil {
+			t.Fatalf("ProjectGet call failed: %v", err)
+		}
+		if len(getResp.Msg.Discs) != 1 || getResp.Msg.Discs[0].Disc != dir || getResp.Msg.Discs[0].ThumbState != "waiting" {
+			t.Fatalf("Expected a single waiting disc '%s', got: %v", dir, getResp.Msg.Discs)
+		}
+
+		// Verify claimed dirs and unknown projects are rejected
+		_, err = client.ProjectAssignDiskDirs(ctx, req)
+		if connect.CodeOf(err) != connect.CodeFailedPrecondition {
+			t.Fatalf("Expected FailedPrecondition for claimed dir, got: %v", err)
+		}
+		req = connect.NewRequest(&v1.ProjectAssignDiskDirsRequest{Project: "Missing Project", Dirs: []string{"Unclaimed 2"}})
+		_, err = client.ProjectAssignDiskDirs(ctx, req)
+		if connect.CodeOf(err) != connect.CodeNotFound {
+			t.Fatalf("Expected NotFound for unknown project, got: %v", err)
+		}
+	})
+
+	t.Run("ProjectCategorizeFiles", func(t *testing.T) {
+		const (
+			project = "Name With Spaces"
+			disc    = "Disc Done Thumbs"
+			file    = "file4.mkv"
+		)
+
+		// Categorize the one uncategorized file in the fixture
+		req := connect.NewRequest(&v1.ProjectCategorizeFilesRequest{
+			Project: project,
+			Files:   []*v1.FileCategory{{Disc: disc, File: file, Category: "extra"}},
+		})
+		if _, err := client.ProjectCategorizeFiles(ctx, req); err != nil {
+			t.Fatalf("ProjectCategorizeFiles call failed: %v", err)
+		}
+
+		// Verify the new category is visible through ProjectGet
+		getResp, err := client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: project}))
+		if err != nil {
+			t.Fatalf("ProjectGet call failed: %v", err)
+		}
+		found := false
+		for _, d := range getResp.Msg.Discs {
+			for _, f := range d.DiscFiles {
+				if d.Disc == disc && f.File == file {
+					found = true
+					if f.Category != "extra" {
+						t.Fatalf("Expected category 'extra' for file '%s', got '%s'", file, f.Category)
+					}
 				}
-			})
+			}
+		}
+		if !found {
+			t.Fatalf("Expected file '%s' not found in response", file)
+		}
+
+		// Verify invalid categories and discs without thumbnails are rejected
+		req = connect.NewRequest(&v1.ProjectCategorizeFilesRequest{
+			Project: project,
+			Files:   []*v1.FileCategory{{Disc: disc, File: file, Category: "bogus"}},
+		})
+		_, err = client.ProjectCategorizeFiles(ctx, req)
+		if connect.CodeOf(err) != connect.CodeInvalidArgument {
+			t.Fatalf("Expected InvalidArgument for invalid category, got: %v", err)
+		}
+		req = connect.NewRequest(&v1.ProjectCategorizeFilesRequest{
+			Project: project,
+			Files:   []*v1.FileCategory{{Disc: "Disc Waiting Thumbs", File: file, Category: "extra"}},
+		})
+		_, err = client.ProjectCategorizeFiles(ctx, req)
+		if connect.CodeOf(err) != connect.CodeFailedPrecondition {
+			t.Fatalf("Expected FailedPrecondition for disc without thumbnails, got: %v", err)
+		}
+	})
+
+	t.Run("ProjectSetMetadata", func(t *testing.T) {
+		// Pick a movie through MovieSearch, as the UI does
+		searchResp, err := client.MovieSearch(ctx, connect.NewRequest(&v1.MovieSearchRequest{PartialTitle: "Movie 2"}))
+		if err != nil {
+			t.Fatalf("MovieSearch call failed: %v", err)
+		}
+		if len(searchResp.Msg.Results) != 1 {
+			t.Fatalf("Expected a single search result, got: %v", searchResp.Msg.Results)
+		}
+		movie := searchResp.Msg.Results[0]
+
+		req := connect.NewRequest(&v1.ProjectSetMetadataRequest{Project: newProject, Id: movie.Id})
+		if _, err := client.ProjectSetMetadata(ctx, req); err != nil {
+			t.Fatalf("ProjectSetMetadata call failed: %v", err)
+		}
+
+		// Verify the chosen metadata is visible through ProjectGet
+		getResp, err := client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: newProject}))
+		if err != nil {
+			t.Fatalf("ProjectGet call failed: %v", err)
+		}
+		if getResp.Msg.SearchResult.GetId() != movie.Id || getResp.Msg.SearchResult.GetTitle() != movie.Title {
+			t.Fatalf("Expected metadata for '%s', got: %v", movie.Title, getResp.Msg.SearchResult)
+		}
+
+		// Verify unknown movies are rejected
+		req = connect.NewRequest(&v1.ProjectSetMetadataRequest{Project: newProject, Id: "no-such-movie"})
+		_, err = client.ProjectSetMetadata(ctx, req)
+		if connect.CodeOf(err) != connect.CodeNotFound {
+			t.Fatalf("Expected NotFound for unknown movie, got: %v", err)
+		}
+	})
+
+	t.Run("ProjectFinish", func(t *testing.T) {
+		// The project created above still has a disc waiting for thumbnails
+		req := connect.NewRequest(&v1.ProjectFinishRequest{Project: newProject})
+		_, err := client.ProjectFinish(ctx, req)
+		if connect.CodeOf(err) != connect.CodeFailedPrecondition {
+			t.Fatalf("Expected FailedPrecondition for unfinished project, got: %v", err)
+		}
+		if !strings.Contains(err.Error(), "thumbnails") {
+			t.Fatalf("Expected error to name the failed rule, got: %v", err)
+		}
+
+		// Verify unknown projects are rejected
+		req = connect.NewRequest(&v1.ProjectFinishRequest{Project: "Missing Project"})
+		_, err = client.ProjectFinish(ctx, req)
+		if connect.CodeOf(err) != connect.CodeNotFound {
+			t.Fatalf("Expected NotFound for unknown project, got: %v", err)
+		}
+	})
+
+	t.Run("ProjectAbandon", func(t *testing.T) {
+		// Abandon the project created above
+		req := connect.NewRequest(&v1.ProjectAbandonRequest{Project: newProject})
+		if _, err := client.ProjectAbandon(ctx, req); err != nil {
+			t.Fatalf("ProjectAbandon call failed: %v", err)
+		}
+
+		// Verify the project is gone from ProjectList
+		listResp, err := client.ProjectList(ctx, connect.NewRequest(&v1.ProjectListRequest{}))
+		if err != nil {
+			t.Fatalf("ProjectList call failed: %v", err)
+		}
+		for _, p := range listResp.Msg.Projects {
+			if p == newProject {
+				t.Fatalf("Expected project '%s' to be abandoned, but it is still listed", newProject)
+			}
+		}
+
+		// Verify the project's disc dir is unclaimed again
+		unclaimedResp, err := client.UnclaimedDiscDirList(ctx, connect.NewRequest(&v1.UnclaimedDiscDirListRequest{}))
+		if err != nil {
+			t.Fatalf("UnclaimedDiscDirList call failed: %v", err)
+		}
+		found := false
+		for _, u := range unclaimedResp.Msg.Dirs {
+			if u == "Unclaimed1" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("Expected dir 'Unclaimed1' to be unclaimed again, got: %v", unclaimedResp.Msg.Dirs)
+		}
+
+		// Verify unknown projects are rejected
+		req = connect.NewRequest(&v1.ProjectAbandonRequest{Project: "Missing Project"})
+		_, err = client.ProjectAbandon(ctx, req)
+		if connect.CodeOf(err) != connect.CodeNotFound {
+			t.Fatalf("Expected NotFound for unknown project, got: %v", err)
 		}
 	})
 
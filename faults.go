@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails" // so error detail headers can use google.rpc types
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration written as a string such as "250ms" in fault
+// files and admin responses.
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// FaultRule describes faults to inject into calls to one procedure.  Latency
+// applies to every matching call.  The fault itself, an error Code or an
+// abrupt Close, applies to the Nth matching call if Nth is set, otherwise to
+// each call with the given Probability, otherwise to every call.
+type FaultRule struct {
+	// Procedure is the full procedure name, e.g.
+	// "/krelinga.video.in.v1.Service/ProjectGet", or "*" for every procedure.
+	Procedure string `json:"procedure"`
+
+	Latency Duration `json:"latency,omitempty"` // delay before the call is handled
+	Jitter  Duration `json:"jitter,omitempty"`  // random extra delay, up to this much
+
+	Code    connect.Code `json:"code,omitempty"`    // error to return, e.g. "unavailable"
+	Message string       `json:"message,omitempty"` // error message; defaults to "injected fault"
+	Close   bool         `json:"close,omitempty"`   // drop the connection instead of responding
+
+	Probability float64 `json:"probability,omitempty"` // chance of the fault on each call
+	Nth         int     `json:"nth,omitempty"`         // only fault the Nth call, counting from 1
+
+	details []*connect.ErrorDetail // attached to Code; only settable by header
+}
+
+// validate reports the first problem with r.
+func (r *FaultRule) validate() error {
+	if r.Procedure == "" {
+		return errors.New("procedure: required")
+	}
+	if r.Procedure != "*" {
+		if _, err := findMethod(r.Procedure); err != nil {
+			return fmt.Errorf("procedure: %w", err)
+		}
+	}
+	if r.Latency < 0 || r.Jitter < 0 {
+		return errors.New("latency and jitter must not be negative")
+	}
+	if r.Code != 0 && (r.Code < connect.CodeCanceled || r.Code > connect.CodeUnauthenticated) {
+		return fmt.Errorf("code: unknown code %v", r.Code)
+	}
+	if r.Code != 0 && r.Close {
+		return errors.New("code and close cannot both be set")
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		return errors.New("probability: must be between 0 and 1")
+	}
+	if r.Nth < 0 {
+		return errors.New("nth: must not be negative")
+	}
+	if r.Nth > 0 && r.Probability > 0 {
+		return errors.New("nth and probability cannot both be set")
+	}
+	if r.Code == 0 && !r.Close {
+		if r.Probability > 0 || r.Nth > 0 || r.Message != "" {
+			return errors.New("probability, nth and message need code or close")
+		}
+		if r.Latency == 0 && r.Jitter == 0 {
+			return errors.New("rule has no effect")
+		}
+	}
+	return nil
+}
+
+func (r *FaultRule) matches(procedure string) bool {
+	return r.Procedure == "*" || r.Procedure == procedure
+}
+
+// ParseFaults parses a list of fault rules from JSON or YAML.
+func ParseFaults(b []byte) ([]FaultRule, error) {
+	var rules []FaultRule
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rules); err != nil && err != io.EOF {
+		return nil, err
+	}
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return rules, nil
+}
+
+// LoadFaults reads a list of fault rules from the JSON or YAML file at path.
+func LoadFaults(path string) ([]FaultRule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := ParseFaults(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Request headers that inject a fault into a single RPC, on top of any rules.
+// Since they only affect the request that carries them, tests running in
+// parallel can use them without interfering with each other.
+const (
+	ErrorHeader        = "X-Stub-Error"         // error code to fail with, e.g. "unavailable"
+	ErrorMessageHeader = "X-Stub-Error-Message" // message for ErrorHeader
+	ErrorDetailHeader  = "X-Stub-Error-Detail"  // google.protobuf.Any in JSON to attach to ErrorHeader; may repeat
+	DelayHeader        = "X-Stub-Delay"         // delay before the call is handled, e.g. "2s"
+	CloseHeader        = "X-Stub-Close"         // "true" to drop the connection instead of responding
+)
+
+// headerRule returns the fault requested by the headers in h, or nil if there
+// are none.
+func headerRule(h http.Header) (*FaultRule, error) {
+	var r FaultRule
+	found := false
+	if v := h.Get(DelayHeader); v != "" {
+		found = true
+		if err := r.Latency.UnmarshalText([]byte(v)); err != nil || r.Latency < 0 {
+			return nil, fmt.Errorf("%s: invalid duration %q", DelayHeader, v)
+		}
+	}
+	if v := h.Get(ErrorHeader); v != "" {
+		found = true
+		if err := r.Code.UnmarshalText([]byte(v)); err != nil || r.Code < connect.CodeCanceled || r.Code > connect.CodeUnauthenticated {
+			return nil, fmt.Errorf("%s: unknown code %q", ErrorHeader, v)
+		}
+	}
+	if v := h.Get(CloseHeader); v != "" {
+		found = true
+		var err error
+		if r.Close, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("%s: invalid bool %q", CloseHeader, v)
+		}
+		if r.Close && r.Code != 0 {
+			return nil, fmt.Errorf("%s and %s cannot both be set", ErrorHeader, CloseHeader)
+		}
+	}
+	r.Message = h.Get(ErrorMessageHeader)
+	for _, v := range h.Values(ErrorDetailHeader) {
+		var a anypb.Any
+		if err := protojson.Unmarshal([]byte(v), &a); err != nil {
+			return nil, fmt.Errorf("%s: %w", ErrorDetailHeader, err)
+		}
+		m, err := a.UnmarshalNew()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ErrorDetailHeader, err)
+		}
+		d, err := connect.NewErrorDetail(m)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ErrorDetailHeader, err)
+		}
+		r.details = append(r.details, d)
+	}
+	if (r.Message != "" || len(r.details) > 0) && r.Code == 0 {
+		return nil, fmt.Errorf("%s and %s need %s", ErrorMessageHeader, ErrorDetailHeader, ErrorHeader)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+// FaultInterceptor implements connect.Interceptor to inject latency, errors
+// and dropped connections into RPCs according to a set of FaultRules.  The
+// zero value injects nothing.
+type FaultInterceptor struct {
+	// Clock times injected latency, so that freezing or advancing it holds
+	// back or releases delayed calls.  If nil, latency is in real time.
+	Clock *VirtualClock
+
+	mu    sync.Mutex
+	rules []FaultRule
+	calls []int // matching calls seen by each rule
+}
+
+// Rules returns the current rules.
+func (f *FaultInterceptor) Rules() []FaultRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FaultRule(nil), f.rules...)
+}
+
+// SetRules replaces the rules, restarting their call counts.
+func (f *FaultInterceptor) SetRules(rules []FaultRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append([]FaultRule(nil), rules...)
+	f.calls = make([]int, len(rules))
+}
+
+// plan counts a call to procedure against the rules, and returns how long to
+// delay it and the rule whose fault it should get, if any.  The first rule to
+// fire wins; the latency of every matching rule adds up.
+func (f *FaultInterceptor) plan(procedure string) (time.Duration, *FaultRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var delay time.Duration
+	var fault *FaultRule
+	for i := range f.rules {
+		r := &f.rules[i]
+		if !r.matches(procedure) {
+			continue
+		}
+		f.calls[i]++
+		delay += time.Duration(r.Latency)
+		if r.Jitter > 0 {
+			delay += rand.N(time.Duration(r.Jitter))
+		}
+		if fault != nil || (r.Code == 0 && !r.Close) {
+			continue
+		}
+		switch {
+		case r.Nth > 0:
+			if f.calls[i] == r.Nth {
+				fault = r
+			}
+		case r.Probability > 0:
+			if rand.Float64() < r.Probability {
+				fault = r
+			}
+		default:
+			fault = r
+		}
+	}
+	return delay, fault
+}
+
+// sleep waits for d on f's clock, or until ctx is done.
+func (f *FaultInterceptor) sleep(ctx context.Context, d time.Duration) error {
+	if f.Clock != nil {
+		return f.Clock.Sleep(ctx, d)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// WrapUnary implements the Interceptor interface for unary RPC calls
+func (f *FaultInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		procedure := req.Spec().Procedure
+		// A call whose fault headers are invalid is not counted against the
+		// rules.  Otherwise the headers apply on top of the rules: their delay
+		// adds to the rules' latency, and their error or close takes priority
+		// over a rule's.
+		hr, err := headerRule(req.Header())
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+		delay, fault := f.plan(procedure)
+		if hr != nil {
+			delay += time.Duration(hr.Latency)
+			if hr.Code != 0 || hr.Close {
+				fault = hr
+			}
+		}
+		if delay > 0 {
+			if err := f.sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+		}
+		if fault == nil {
+			return next(ctx, req)
+		}
+		if fault.Close {
+			// net/http aborts the response without logging a stack trace.
+			// LoggingInterceptor logs and journals the call first.
+			panic(http.ErrAbortHandler)
+		}
+		msg := fault.Message
+		if msg == "" {
+			msg = "injected fault"
+		}
+		cerr := connect.NewError(fault.Code, errors.New(msg))
+		for _, d := range fault.details {
+			cerr.AddDetail(d)
+		}
+		return nil, cerr
+	}
+}
+
+// WrapStreamingClient implements the Interceptor interface for streaming client calls
+func (f *FaultInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next // No streaming clients in this stub service
+}
+
+// WrapStreamingHandler implements the Interceptor interface for streaming handler calls
+func (f *FaultInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next // No streaming handlers in this stub service
+}
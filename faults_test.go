@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"buf.build/gen/go/krelinga/proto/connectrpc/go/krelinga/video/in/v1/inv1connect"
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"connectrpc.com/connect"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+func TestParseFaults(t *testing.T) {
+	rules, err := ParseFaults([]byte(`
+- procedure: /krelinga.video.in.v1.Service/ProjectGet
+  latency: 250ms
+  jitter: 1s
+  code: unavailable
+  probability: 0.5
+- procedure: "*"
+  nth: 3
+  close: true
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(rules))
+	}
+	if r := rules[0]; r.Latency != Duration(250*time.Millisecond) || r.Jitter != Duration(time.Second) || r.Code != connect.CodeUnavailable || r.Probability != 0.5 {
+		t.Errorf("Unexpected first rule: %+v", r)
+	}
+	if r := rules[1]; r.Procedure != "*" || r.Nth != 3 || !r.Close {
+		t.Errorf("Unexpected second rule: %+v", r)
+	}
+
+	tests := []struct {
+		name    string
+		faults  string
+		wantErr string
+	}{
+		{name: "unknown field", faults: `[{procedure: "*", code: internal, sometimes: true}]`, wantErr: "sometimes"},
+		{name: "no procedure", faults: `[{code: internal}]`, wantErr: "procedure: required"},
+		{name: "unknown procedure", faults: `[{procedure: /krelinga.video.in.v1.Service/Nope, code: internal}]`, wantErr: "unknown procedure"},
+		{name: "bare method", faults: `[{procedure: ProjectGet, code: internal}]`, wantErr: "unknown procedure"},
+		{name: "unknown code", faults: `[{procedure: "*", code: broken}]`, wantErr: "broken"},
+		{name: "bad duration", faults: `[{procedure: "*", latency: soon}]`, wantErr: "soon"},
+		{name: "code and close", faults: `[{procedure: "*", code: internal, close: true}]`, wantErr: "cannot both"},
+		{name: "probability range", faults: `[{procedure: "*", code: internal, probability: 2}]`, wantErr: "probability"},
+		{name: "nth without fault", faults: `[{procedure: "*", nth: 2}]`, wantErr: "need code or close"},
+		{name: "no effect", faults: `[{procedure: "*"}]`, wantErr: "no effect"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFaults([]byte(tt.faults))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected an error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFaultInterceptor(t *testing.T) {
+	faults := &FaultInterceptor{}
+	store := NewModelStore(data.Clone(), nil)
+	_, handler := inv1connect.NewServiceHandler(
+		NewStubService(store),
+		connect.WithInterceptors(faults, &SessionInterceptor{Store: store}),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	ctx := context.Background()
+	list := func() error {
+		_, err := client.ProjectList(ctx, connect.NewRequest(&v1.ProjectListRequest{}))
+		return err
+	}
+	get := func() error {
+		_, err := client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: "Name With Spaces"}))
+		return err
+	}
+
+	// An unconditional error only affects its own procedure.
+	faults.SetRules([]FaultRule{{Procedure: inv1connect.ServiceProjectGetProcedure, Code: connect.CodeUnavailable, Message: "down"}})
+	if err := get(); connect.CodeOf(err) != connect.CodeUnavailable || !strings.Contains(err.Error(), "down") {
+		t.Errorf("Expected injected Unavailable, got: %v", err)
+	}
+	if err := list(); err != nil {
+		t.Errorf("Expected ProjectList to be unaffected, got: %v", err)
+	}
+
+	// Nth only fails one call.
+	faults.SetRules([]FaultRule{{Procedure: "*", Code: connect.CodeInternal, Nth: 2}})
+	for i, wantErr := range []bool{false, true, false} {
+		err := list()
+		if wantErr && connect.CodeOf(err) != connect.CodeInternal || !wantErr && err != nil {
+			t.Errorf("Call %d: expected failure %v, got: %v", i+1, wantErr, err)
+		}
+	}
+
+	// Latency delays the call.
+	faults.SetRules([]FaultRule{{Procedure: "*", Latency: Duration(50 * time.Millisecond)}})
+	start := time.Now()
+	if err := list(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("Expected a delay of at least 50ms, got %v", d)
+	}
+
+	// Latency is measured on the clock, so a frozen clock holds the call
+	// until it is advanced.
+	faults.Clock = store.Clock()
+	faults.Clock.Freeze()
+	faults.SetRules([]FaultRule{{Procedure: "*", Latency: Duration(time.Hour)}})
+	done := make(chan error)
+	go func() { done <- list() }()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the call to wait for the frozen clock, got: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	faults.Clock.Advance(time.Hour)
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	faults.Clock.Resume()
+
+	// Close drops the connection without a response.
+	faults.SetRules([]FaultRule{{Procedure: "*", Close: true}})
+	if err := list(); err == nil {
+		t.Error("Expected the call to fail")
+	}
+
+	faults.SetRules(nil)
+	if err := list(); err != nil {
+		t.Errorf("Expected no faults after clearing the rules, got: %v", err)
+	}
+}
+
+func TestFaultHeaders(t *testing.T) {
+	faults := &FaultInterceptor{}
+	store := NewModelStore(data.Clone(), nil)
+	_, handler := inv1connect.NewServiceHandler(
+		NewStubService(store),
+		connect.WithInterceptors(faults, &SessionInterceptor{Store: store}),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	list := func(headers map[string][]string) error {
+		req := connect.NewRequest(&v1.ProjectListRequest{})
+		for k, vs := range headers {
+			for _, v := range vs {
+				req.Header().Add(k, v)
+			}
+		}
+		_, err := client.ProjectList(context.Background(), req)
+		return err
+	}
+
+	err := list(map[string][]string{
+		ErrorHeader:        {"resource_exhausted"},
+		ErrorMessageHeader: {"slow down"},
+		ErrorDetailHeader: {
+			`{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "QUOTA", "domain": "stub"}`,
+			`{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "3s"}`,
+		},
+	})
+	var cerr *connect.Error
+	if !errors.As(err, &cerr) || cerr.Code() != connect.CodeResourceExhausted || cerr.Message() != "slow down" {
+		t.Fatalf("Expected injected ResourceExhausted, got: %v", err)
+	}
+	if len(cerr.Details()) != 2 {
+		t.Fatalf("Expected 2 error details, got %d", len(cerr.Details()))
+	}
+	m, err := cerr.Details()[0].Value()
+	if err != nil {
+		t.Fatalf("Failed to decode detail: %v", err)
+	}
+	if info, ok := m.(*errdetails.ErrorInfo); !ok || info.Reason != "QUOTA" {
+		t.Errorf("Expected ErrorInfo with reason QUOTA, got %v", m)
+	}
+
+	start := time.Now()
+	if err := list(map[string][]string{DelayHeader: {"50ms"}}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("Expected a delay of at least 50ms, got %v", d)
+	}
+
+	if err := list(map[string][]string{CloseHeader: {"true"}}); err == nil {
+		t.Error("Expected the call to fail")
+	}
+
+	// The headers only affect the request that carries them.
+	if err := list(nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	bad := []map[string][]string{
+		{ErrorHeader: {"broken"}},
+		{DelayHeader: {"soon"}},
+		{CloseHeader: {"maybe"}},
+		{ErrorHeader: {"internal"}, CloseHeader: {"true"}},
+		{ErrorMessageHeader: {"no code"}},
+		{ErrorHeader: {"internal"}, ErrorDetailHeader: {`{"@type": "type.googleapis.com/Unknown"}`}},
+	}
+	for _, headers := range bad {
+		if err := list(headers); connect.CodeOf(err) != connect.CodeInvalidArgument {
+			t.Errorf("Expected InvalidArgument for %v, got: %v", headers, err)
+		}
+	}
+
+	// Calls with invalid fault headers do not count towards the rules; valid
+	// headers apply on top of them.
+	faults.SetRules([]FaultRule{{Procedure: "*", Code: connect.CodeInternal, Nth: 2}})
+	if err := list(map[string][]string{ErrorHeader: {"broken"}}); connect.CodeOf(err) != connect.CodeInvalidArgument {
+		t.Errorf("Expected InvalidArgument, got: %v", err)
+	}
+	for i, wantErr := range []bool{false, true, false} {
+		err := list(map[string][]string{DelayHeader: {"1ms"}})
+		if wantErr && connect.CodeOf(err) != connect.CodeInternal || !wantErr && err != nil {
+			t.Errorf("Call %d: expected failure %v, got: %v", i+1, wantErr, err)
+		}
+	}
+
+	// A header error takes priority over a rule's, and the rule's latency
+	// still applies.
+	faults.SetRules([]FaultRule{{Procedure: "*", Code: connect.CodeInternal, Latency: Duration(50 * time.Millisecond)}})
+	start = time.Now()
+	if err := list(map[string][]string{ErrorHeader: {"unavailable"}}); connect.CodeOf(err) != connect.CodeUnavailable {
+		t.Errorf("Expected the header's Unavailable, got: %v", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("Expected the rule's delay of at least 50ms, got %v", d)
+	}
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"gopkg.in/yaml.v3"
+)
+
+// FixtureError reports a problem with a specific field of a fixture file.
+type FixtureError struct {
+	Line   int
+	Column int
+	Field  string // e.g. "projects[1].discs[0].thumbState"
+	Err    error
+}
+
+func (e *FixtureError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %d: %s: %v", e.Line, e.Column, e.Field, e.Err)
+}
+
+func (e *FixtureError) Unwrap() error {
+	return e.Err
+}
+
+func fixtureError(n *yaml.Node, field string, format string, args ...any) error {
+	return &FixtureError{Line: n.Line, Column: n.Column, Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+// LoadFixture reads a Model from a JSON or YAML fixture file.
+func LoadFixture(path string) (*Model, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseFixture(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// ParseFixture decodes a Model from the contents of a fixture file.  Fixtures
+// are parsed as YAML, which also accepts JSON.  Projects, metadata and the
+// requests and responses of mappings use the protojson representation of their
+// v1 messages, for example:
+//
+//	projects:
+//	  - project: Example
+//	    discs:
+//	      - disc: Disc 1
+//	        thumbState: waiting
+//	unclaimed: [Unclaimed1]
+//	metadata:
+//	  - id: "1001"
+//	    title: Movie 1
+//	closed:
+//	  Old Project: finished
+//	mappings:
+//	  - procedure: /krelinga.video.in.v1.Service/ProjectGet
+//	    request: {project: Canned}
+//	    response: {project: Canned}
+//	  - procedure: /krelinga.video.in.v1.Service/ProjectGet
+//	    priority: -1
+//	    match:
+//	      - {field: project, prefix: "Test "}
+//	    response: {project: Test}
+func ParseFixture(b []byte) (*Model, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	m := &Model{}
+	if len(doc.Content) == 0 {
+		return m, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fixtureError(root, "", "expected an object")
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, val := root.Content[i], root.Content[i+1]
+		var err error
+		switch key.Value {
+		case "projects":
+			m.Projects, err = decodeMessages[*v1.ProjectGetResponse](val, key.Value)
+			if err == nil {
+				err = checkUniqueProjects(val, m.Projects)
+			}
+		case "unclaimed":
+			m.Unclaimed, err = decodeStrings(val, key.Value)
+		case "metadata":
+			m.Metadata, err = decodeMessages[*v1.MovieSearchResult](val, key.Value)
+		case "closed":
+			m.Closed, err = decodeClosed(val, key.Value)
+		case "mappings":
+			m.Mappings, err = decodeMappings(val, key.Value)
+		default:
+			err = fixtureError(key, key.Value, "unknown field")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// MarshalFixture encodes m as a JSON fixture that ParseFixture can read back.
+func (m *Model) MarshalFixture() ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	doc := struct {
+		Projects  []json.RawMessage       `json:"projects"`
+		Unclaimed []string                `json:"unclaimed"`
+		Metadata  []json.RawMessage       `json:"metadata"`
+		Closed    map[string]ProjectState `json:"closed,omitempty"`
+		Mappings  []mappingJSON           `json:"mappings,omitempty"`
+	}{
+		Projects:  make([]json.RawMessage, 0, len(m.Projects)),
+		Unclaimed: append([]string{}, m.Unclaimed...),
+		Metadata:  make([]json.RawMessage, 0, len(m.Metadata)),
+		Closed:    m.Closed,
+	}
+	for _, p := range m.Projects {
+		b, err := protojson.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		doc.Projects = append(doc.Projects, b)
+	}
+	for _, md := range m.Metadata {
+		b, err := protojson.Marshal(md)
+		if err != nil {
+			return nil, err
+		}
+		doc.Metadata = append(doc.Metadata, b)
+	}
+	for _, mp := range m.Mappings {
+		j, err := newMappingJSON(mp)
+		if err != nil {
+			return nil, err
+		}
+		doc.Mappings = append(doc.Mappings, j)
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// mappingJSON is the fixture representation of a RequestResponseMapping.
+type mappingJSON struct {
+	Name      string          `json:"name,omitempty"`
+	Procedure string          `json:"procedure"`
+	Priority  int             `json:"priority,omitempty"`
+	Request   json.RawMessage `json:"request"`
+	Exact     bool            `json:"exact,omitempty"`
+	Match     []matcherJSON   `json:"match,omitempty"`
+	Response  json.RawMessage `json:"response,omitempty"`
+	Mode      SequenceMode    `json:"mode,omitempty"`
+	Sequence  []replyJSON     `json:"sequence,omitempty"`
+
+	Passthrough bool `json:"passthrough,omitempty"`
+}
+
+// replyJSON is the fixture representation of a MappingReply.
+type replyJSON struct {
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    *errorJSON      `json:"error,omitempty"`
+}
+
+type errorJSON struct {
+	Code    connect.Code `json:"code"`
+	Message string       `json:"message,omitempty"`
+}
+
+// matcherJSON is the fixture representation of a FieldMatcher.
+type matcherJSON struct {
+	Field    string `json:"field"`
+	Regex    string `json:"regex,omitempty"`
+	Prefix   string `json:"prefix,omitempty"`
+	Contains string `json:"contains,omitempty"`
+}
+
+func newMappingJSON(mp *RequestResponseMapping) (mappingJSON, error) {
+	j := mappingJSON{Name: mp.Name, Procedure: mp.Procedure, Priority: mp.Priority, Exact: mp.Exact, Mode: mp.Mode, Passthrough: mp.Passthrough}
+	for _, f := range mp.Matchers {
+		mj := matcherJSON{Field: f.Field, Prefix: f.Prefix, Contains: f.Contains}
+		if f.Regex != nil {
+			mj.Regex = f.Regex.String()
+		}
+		j.Match = append(j.Match, mj)
+	}
+	var err error
+	if j.Request, err = protojson.Marshal(mp.Request); err != nil {
+		return j, err
+	}
+	if mp.Passthrough {
+		return j, nil
+	}
+	if len(mp.Sequence) == 0 {
+		j.Response, err = protojson.Marshal(mp.Response)
+		return j, err
+	}
+	for _, r := range mp.Sequence {
+		var rj replyJSON
+		if r.Code != 0 {
+			rj.Error = &errorJSON{Code: r.Code, Message: r.Message}
+		} else if rj.Response, err = protojson.Marshal(r.Response); err != nil {
+			return j, err
+		}
+		j.Sequence = append(j.Sequence, rj)
+	}
+	return j, nil
+}
+
+func isNull(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
+
+func checkUniqueProjects(n *yaml.Node, projects []*v1.ProjectGetResponse) error {
+	for i, p := range projects {
+		duplicate := slices.ContainsFunc(projects[:i], func(q *v1.ProjectGetResponse) bool {
+			return q.Project == p.Project
+		})
+		if duplicate {
+			return fixtureError(n.Content[i], fmt.Sprintf("projects[%d].project", i), "duplicate project %q", p.Project)
+		}
+	}
+	return nil
+}
+
+func decodeStrings(n *yaml.Node, field string) ([]string, error) {
+	if isNull(n) {
+		return nil, nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		return nil, fixtureError(n, field, "expected a list")
+	}
+	var out []string
+	for i, item := range n.Content {
+		if item.Kind != yaml.ScalarNode {
+			return nil, fixtureError(item, fmt.Sprintf("%s[%d]", field, i), "expected a string")
+		}
+		out = append(out, item.Value)
+	}
+	return out, nil
+}
+
+func decodeClosed(n *yaml.Node, field string) (map[string]ProjectState, error) {
+	if isNull(n) {
+		return nil, nil
+	}
+	if n.Kind != yaml.MappingNode {
+		return nil, fixtureError(n, field, "expected an object")
+	}
+	out := make(map[string]ProjectState)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		state := ProjectState(val.Value)
+		if val.Kind != yaml.ScalarNode || (state != ProjectFinished && state != ProjectAbandoned) {
+			return nil, fixtureError(val, field+"."+key.Value, "invalid state %q, want %s or %s", val.Value, ProjectFinished, ProjectAbandoned)
+		}
+		out[key.Value] = state
+	}
+	return out, nil
+}
+
+func decodeMappings(n *yaml.Node, field string) ([]*RequestResponseMapping, error) {
+	if isNull(n) {
+		return nil, nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		return nil, fixtureError(n, field, "expected a list")
+	}
+	var out []*RequestResponseMapping
+	for i, item := range n.Content {
+		mp, err := decodeMapping(item, fmt.Sprintf("%s[%d]", field, i))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, mp)
+	}
+	return out, nil
+}
+
+// decodeMapping decodes a single mapping.  Its request and responses are
+// decoded as the input and output types of its procedure, and default to
+// empty messages, and its matchers are checked against the input type.
+func decodeMapping(n *yaml.Node, field string) (*RequestResponseMapping, error) {
+	if n.Kind != yaml.MappingNode {
+		return nil, fixtureError(n, field, "expected an object")
+	}
+	vals := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		switch key.Value {
+		case "name", "procedure", "priority", "request", "exact", "match", "response", "mode", "sequence", "passthrough":
+			vals[key.Value] = val
+		default:
+			return nil, fixtureError(key, field+"."+key.Value, "unknown field")
+		}
+	}
+	pn := vals["procedure"]
+	if pn == nil {
+		return nil, fixtureError(n, field+".procedure", "required")
+	}
+	method, err := findMethod(pn.Value)
+	if err != nil {
+		return nil, fixtureError(pn, field+".procedure", "%v", err)
+	}
+	mp := &RequestResponseMapping{Procedure: pn.Value}
+	if mp.Request, err = decodeMethodMessage(vals["request"], pn, method.Input(), field+".request"); err != nil {
+		return nil, err
+	}
+	if n := vals["passthrough"]; n != nil {
+		if err := n.Decode(&mp.Passthrough); err != nil {
+			return nil, fixtureError(n, field+".passthrough", "expected a boolean")
+		}
+		if mp.Passthrough && (vals["response"] != nil || vals["sequence"] != nil) {
+			return nil, fixtureError(n, field+".passthrough", "cannot be used with response or sequence")
+		}
+	}
+	if n := vals["sequence"]; n != nil {
+		if vals["response"] != nil {
+			return nil, fixtureError(n, field+".sequence", "cannot be used with response")
+		}
+		if mp.Sequence, err = decodeReplies(n, pn, method.Output(), field+".sequence"); err != nil {
+			return nil, err
+		}
+	} else if mp.Response, err = decodeMethodMessage(vals["response"], pn, method.Output(), field+".response"); err != nil {
+		return nil, err
+	}
+	if n := vals["mode"]; n != nil {
+		switch mode := SequenceMode(n.Value); mode {
+		case SequenceStop, SequenceCycle, SequenceFail:
+			mp.Mode = mode
+		default:
+			return nil, fixtureError(n, field+".mode", "invalid mode %q, want %s, %s or %s", n.Value, SequenceStop, SequenceCycle, SequenceFail)
+		}
+	}
+	if n := vals["name"]; n != nil {
+		if n.Kind != yaml.ScalarNode {
+			return nil, fixtureError(n, field+".name", "expected a string")
+		}
+		mp.Name = n.Value
+	}
+	if n := vals["priority"]; n != nil {
+		if err := n.Decode(&mp.Priority); err != nil {
+			return nil, fixtureError(n, field+".priority", "expected an integer")
+		}
+	}
+	if n := vals["exact"]; n != nil {
+		if err := n.Decode(&mp.Exact); err != nil {
+			return nil, fixtureError(n, field+".exact", "expected a boolean")
+		}
+	}
+	if n := vals["match"]; n != nil && !isNull(n) {
+		if n.Kind != yaml.SequenceNode {
+			return nil, fixtureError(n, field+".match", "expected a list")
+		}
+		for i, item := range n.Content {
+			f, err := decodeMatcher(item, method.Input(), fmt.Sprintf("%s.match[%d]", field, i))
+			if err != nil {
+				return nil, err
+			}
+			mp.Matchers = append(mp.Matchers, f)
+		}
+	}
+	return mp, nil
+}
+
+// decodeReplies decodes a mapping's sequence of replies, each either a
+// response of type md or an error.
+func decodeReplies(n, procedure *yaml.Node, md protoreflect.MessageDescriptor, field string) ([]MappingReply, error) {
+	if n.Kind != yaml.SequenceNode || len(n.Content) == 0 {
+		return nil, fixtureError(n, field, "expected a non-empty list")
+	}
+	var out []MappingReply
+	for i, item := range n.Content {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		if item.Kind != yaml.MappingNode || len(item.Content) != 2 {
+			return nil, fixtureError(item, f, "expected an object with one of response and error")
+		}
+		key, val := item.Content[0], item.Content[1]
+		var r MappingReply
+		switch key.Value {
+		case "response":
+			var err error
+			if r.Response, err = decodeMethodMessage(val, procedure, md, f+".response"); err != nil {
+				return nil, err
+			}
+		case "error":
+			var e struct {
+				Code    string `yaml:"code"`
+				Message string `yaml:"message"`
+			}
+			if err := val.Decode(&e); err != nil {
+				return nil, fixtureError(val, f+".error", "expected an object with code and message")
+			}
+			if err := r.Code.UnmarshalText([]byte(e.Code)); err != nil || r.Code < connect.CodeCanceled || r.Code > connect.CodeUnauthenticated {
+				return nil, fixtureError(val, f+".error.code", "unknown code %q", e.Code)
+			}
+			r.Message = e.Message
+		default:
+			return nil, fixtureError(key, f+"."+key.Value, "unknown field")
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// decodeMatcher decodes a FieldMatcher for requests of type md.
+func decodeMatcher(n *yaml.Node, md protoreflect.MessageDescriptor, field string) (FieldMatcher, error) {
+	var f FieldMatcher
+	if n.Kind != yaml.MappingNode {
+		return f, fixtureError(n, field, "expected an object")
+	}
+	predicates := 0
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		if val.Kind != yaml.ScalarNode {
+			return f, fixtureError(val, field+"."+key.Value, "expected a string")
+		}
+		switch key.Value {
+		case "field":
+			f.Field = val.Value
+		case "regex":
+			re, err := regexp.Compile(val.Value)
+			if err != nil {
+				return f, fixtureError(val, field+".regex", "%v", err)
+			}
+			f.Regex = re
+			predicates++
+		case "prefix":
+			f.Prefix = val.Value
+			predicates++
+		case "contains":
+			if val.Value == "" {
+				return f, fixtureError(val, field+".contains", "must not be empty")
+			}
+			f.Contains = val.Value
+			predicates++
+		default:
+			return f, fixtureError(key, field+"."+key.Value, "unknown field")
+		}
+	}
+	if f.Field == "" {
+		return f, fixtureError(n, field+".field", "required")
+	}
+	if predicates != 1 {
+		return f, fixtureError(n, field, "need exactly one of regex, prefix and contains")
+	}
+	if err := f.check(md); err != nil {
+		return f, fixtureError(n, field, "%v", err)
+	}
+	return f, nil
+}
+
+// decodeMethodMessage decodes n into a new message of type md.  If n is
+// missing, the message is left empty; errors that belong to no node are
+// reported at procedure.
+func decodeMethodMessage(n, procedure *yaml.Node, md protoreflect.MessageDescriptor, field string) (proto.Message, error) {
+	msg, err := newMessage(md)
+	if err != nil {
+		return nil, fixtureError(procedure, field, "%v", err)
+	}
+	if n == nil || isNull(n) {
+		return msg, nil
+	}
+	if err := decodeMessage(n, msg, field); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func decodeMessages[T proto.Message](n *yaml.Node, field string) ([]T, error) {
+	if isNull(n) {
+		return nil, nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		return nil, fixtureError(n, field, "expected a list")
+	}
+	var out []T
+	for i, item := range n.Content {
+		var zero T
+		msg := zero.ProtoReflect().New().Interface().(T)
+		if err := decodeMessage(item, msg, fmt.Sprintf("%s[%d]", field, i)); err != nil {
+			return nil, err
+		}
+		out = append(out, msg)
+	}
+	return out, nil
+}
+
+// decodeMessage decodes n into msg using protojson.  The node is validated
+// field by field first, so that mistakes are reported at the line that made
+// them rather than at an offset into the JSON generated from the YAML.
+func decodeMessage(n *yaml.Node, msg proto.Message, field string) error {
+	v, err := messageJSON(n, msg.ProtoReflect().Descriptor(), field)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fixtureError(n, field, "%v", err)
+	}
+	if err := protojson.Unmarshal(b, msg); err != nil {
+		return fixtureError(n, field, "%v", err)
+	}
+	return nil
+}
+
+func messageJSON(n *yaml.Node, md protoreflect.MessageDescriptor, field string) (map[string]any, error) {
+	if n.Kind != yaml.MappingNode {
+		return nil, fixtureError(n, field, "expected an object")
+	}
+	out := make(map[string]any)
+	fields := md.Fields()
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		path := field + "." + key.Value
+		fd := fields.ByJSONName(key.Value)
+		if fd == nil {
+			fd = fields.ByTextName(key.Value)
+		}
+		if fd == nil {
+			return nil, fixtureError(key, path, "unknown field")
+		}
+		if isNull(val) {
+			continue
+		}
+		v, err := fieldJSON(val, fd, path)
+		if err != nil {
+			return nil, err
+		}
+		out[fd.JSONName()] = v
+	}
+	return out, nil
+}
+
+func fieldJSON(n *yaml.Node, fd protoreflect.FieldDescriptor, field string) (any, error) {
+	if fd.IsMap() {
+		var v any
+		if err := n.Decode(&v); err != nil {
+			return nil, fixtureError(n, field, "%v", err)
+		}
+		return v, nil
+	}
+	if !fd.IsList() {
+		return valueJSON(n, fd, field)
+	}
+	if n.Kind != yaml.SequenceNode {
+		return nil, fixtureError(n, field, "expected a list")
+	}
+	items := make([]any, 0, len(n.Content))
+	for i, item := range n.Content {
+		v, err := valueJSON(item, fd, fmt.Sprintf("%s[%d]", field, i))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
+
+func valueJSON(n *yaml.Node, fd protoreflect.FieldDescriptor, field string) (any, error) {
+	if fd.Message() != nil {
+		return messageJSON(n, fd.Message(), field)
+	}
+	if n.Kind != yaml.ScalarNode {
+		return nil, fixtureError(n, field, "expected a %s value", fd.Kind())
+	}
+	var v any
+	switch fd.Kind() {
+	case protoreflect.StringKind, protoreflect.BytesKind, protoreflect.EnumKind:
+		// Use the literal text so that e.g. an unquoted date or number in a
+		// string field keeps its spelling.
+		v = n.Value
+	default:
+		if err := n.Decode(&v); err != nil {
+			return nil, fixtureError(n, field, "%v", err)
+		}
+	}
+	if !validScalar(fd, v) {
+		return nil, fixtureError(n, field, "invalid %s value %q", fd.Kind(), n.Value)
+	}
+	return v, nil
+}
+
+// validScalar reports whether protojson accepts v as a single value of fd.
+func validScalar(fd protoreflect.FieldDescriptor, v any) bool {
+	var wrapped any = v
+	if fd.IsList() {
+		wrapped = []any{v}
+	}
+	b, err := json.Marshal(map[string]any{fd.JSONName(): wrapped})
+	if err != nil {
+		return false
+	}
+	return protojson.Unmarshal(b, dynamicpb.NewMessage(fd.ContainingMessage())) == nil
+}
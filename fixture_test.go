@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseFixture(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+	}{
+		{
+			name: "yaml",
+			fixture: `
+projects:
+  - project: Example
+    discs:
+      - disc: Disc 1
+        thumb_state: done
+        discFiles:
+          - file: file1.mkv
+            category: main_title
+            numChapters: 10
+    searchResult:
+      id: 1001
+      releaseDate: 2023-01-01
+unclaimed: [Unclaimed1]
+metadata:
+  - id: "1001"
+    title: Movie 1
+    genres: [Action]
+closed:
+  Old Project: finished
+`,
+		},
+		{
+			name: "json",
+			fixture: `{
+	"projects": [{
+		"project": "Example",
+		"discs": [{
+			"disc": "Disc 1",
+			"thumbState": "done",
+			"discFiles": [{"file": "file1.mkv", "category": "main_title", "numChapters": 10}]
+		}],
+		"searchResult": {"id": "1001", "releaseDate": "2023-01-01"}
+	}],
+	"unclaimed": ["Unclaimed1"],
+	"metadata": [{"id": "1001", "title": "Movie 1", "genres": ["Action"]}],
+	"closed": {"Old Project": "finished"}
+}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseFixture([]byte(tt.fixture))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			p := m.GetProject("Example")
+			if p == nil {
+				t.Fatal("Expected project 'Example'")
+			}
+			if len(p.Discs) != 1 || p.Discs[0].ThumbState != ThumbStateDone {
+				t.Fatalf("Expected a single done disc, got %v", p.Discs)
+			}
+			if f := p.Discs[0].DiscFiles; len(f) != 1 || f[0].Category != CategoryMainTitle || f[0].NumChapters != 10 {
+				t.Errorf("Expected a single main title with 10 chapters, got %v", f)
+			}
+			// Unquoted YAML numbers and dates must keep their spelling in string fields.
+			if p.SearchResult.GetId() != "1001" || p.SearchResult.GetReleaseDate() != "2023-01-01" {
+				t.Errorf("Expected search result 1001 released 2023-01-01, got %v", p.SearchResult)
+			}
+			if got, want := m.UnclaimedDirs(), []string{"Unclaimed1"}; !slices.Equal(got, want) {
+				t.Errorf("Expected unclaimed %v, got %v", want, got)
+			}
+			if got := m.SearchMetadata("Movie"); len(got) != 1 || got[0].Id != "1001" {
+				t.Errorf("Expected metadata for movie 1001, got %v", got)
+			}
+			if state, _ := m.ProjectState("Old Project"); state != ProjectFinished {
+				t.Errorf("Expected 'Old Project' to be %s, got %q", ProjectFinished, state)
+			}
+		})
+	}
+}
+
+func TestParseFixtureErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		fixture   string
+		wantLine  int
+		wantField string
+		wantErr   string
+	}{
+		{
+			name:      "unknown top-level field",
+			fixture:   "projects: []\nprojcts: []\n",
+			wantLine:  2,
+			wantField: "projcts",
+			wantErr:   "unknown field",
+		},
+		{
+			name:      "unknown message field",
+			fixture:   "projects:\n  - project: A\n    discs:\n      - disc: D\n        thumbStat: done\n",
+			wantLine:  5,
+			wantField: "projects[0].discs[0].thumbStat",
+			wantErr:   "unknown field",
+		},
+		{
+			name:      "invalid number",
+			fixture:   "projects:\n  - project: A\n    discs:\n      - disc: D\n        discFiles:\n          - file: f\n            numChapters: lots\n",
+			wantLine:  7,
+			wantField: "projects[0].discs[0].discFiles[0].numChapters",
+			wantErr:   "invalid int32 value",
+		},
+		{
+			name:      "wrong shape",
+			fixture:   "metadata:\n  - id: \"1\"\n    genres: Drama\n",
+			wantLine:  3,
+			wantField: "metadata[0].genres",
+			wantErr:   "expected a list",
+		},
+		{
+			name:      "mapping without procedure",
+			fixture:   "mappings:\n  - response: {projects: [A]}\n",
+			wantLine:  2,
+			wantField: "mappings[0].procedure",
+			wantErr:   "required",
+		},
+		{
+			name:      "mapping with unknown procedure",
+			fixture:   "mappings:\n  - procedure: /krelinga.video.in.v1.Service/ProjectGone\n",
+			wantLine:  2,
+			wantField: "mappings[0].procedure",
+			wantErr:   "unknown procedure",
+		},
+		{
+			name:      "mapping response of the wrong type",
+			fixture:   "mappings:\n  - procedure: /krelinga.video.in.v1.Service/ProjectList\n    response: {dirs: [A]}\n",
+			wantLine:  3,
+			wantField: "mappings[0].response.dirs",
+			wantErr:   "unknown field",
+		},
+		{
+			name:      "matcher on a non-string field",
+			fixture:   "mappings:\n  - procedure: /krelinga.video.in.v1.Service/ProjectAssignDiskDirs\n    match:\n      - {field: dirs, regex: x}\n      - {field: project, contains: x}\n",
+			wantLine:  5,
+			wantField: "mappings[0].match[1]",
+			wantErr:   "contains needs a repeated field",
+		},
+		{
+			name:      "matcher with two predicates",
+			fixture:   "mappings:\n  - procedure: /krelinga.video.in.v1.Service/ProjectGet\n    match:\n      - {field: project, regex: x, prefix: y}\n",
+			wantLine:  4,
+			wantField: "mappings[0].match[0]",
+			wantErr:   "exactly one",
+		},
+		{
+			name:      "matcher with a bad regex",
+			fixture:   "mappings:\n  - procedure: /krelinga.video.in.v1.Service/ProjectGet\n    match:\n      - {field: project, regex: \"(\"}\n",
+			wantLine:  4,
+			wantField: "mappings[0].match[0].regex",
+			wantErr:   "missing closing",
+		},
+		{
+			name:      "mapping with response and sequence",
+			fixture:   "mappings:\n  - procedure: /krelinga.video.in.v1.Service/ProjectList\n    response: {}\n    sequence: [{response: {}}]\n",
+			wantLine:  4,
+			wantField: "mappings[0].sequence",
+			wantErr:   "cannot be used with response",
+		},
+		{
+			name:      "sequence error with an unknown code",
+			fixture:   "mappings:\n  - procedure: /krelinga.video.in.v1.Service/ProjectList\n    sequence:\n      - response: {}\n      - error: {code: broken}\n",
+			wantLine:  5,
+			wantField: "mappings[0].sequence[1].error.code",
+			wantErr:   "unknown code",
+		},
+		{
+			name:      "passthrough mapping with a response",
+			fixture:   "mappings:\n  - procedure: /krelinga.video.in.v1.Service/ProjectList\n    passthrough: true\n    response: {}\n",
+			wantLine:  3,
+			wantField: "mappings[0].passthrough",
+			wantErr:   "cannot be used with response",
+		},
+		{
+			name:      "invalid sequence mode",
+			fixture:   "mappings:\n  - procedure: /krelinga.video.in.v1.Service/ProjectList\n    mode: loop\n    sequence: [{response: {}}]\n",
+			wantLine:  3,
+			wantField: "mappings[0].mode",
+			wantErr:   "invalid mode",
+		},
+		{
+			name:      "duplicate project",
+			fixture:   "projects:\n  - project: A\n  - project: A\n",
+			wantLine:  3,
+			wantField: "projects[1].project",
+			wantErr:   "duplicate project",
+		},
+		{
+			name:      "invalid closed state",
+			fixture:   "closed:\n  A: active\n",
+			wantLine:  2,
+			wantField: "closed.A",
+			wantErr:   "invalid state",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFixture([]byte(tt.fixture))
+			var fe *FixtureError
+			if !errors.As(err, &fe) {
+				t.Fatalf("Expected a FixtureError, got: %v", err)
+			}
+			if fe.Line != tt.wantLine || fe.Field != tt.wantField {
+				t.Errorf("Expected error at line %d field %s, got line %d field %s", tt.wantLine, tt.wantField, fe.Line, fe.Field)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error to contain %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
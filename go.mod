@@ -8,7 +8,9 @@ require (
 	connectrpc.com/connect v1.18.1
 	github.com/krelinga/go-iters v0.1.3
 	golang.org/x/net v0.42.0
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250528174236-200df99c418a
 	google.golang.org/protobuf v1.36.6
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
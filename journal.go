@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// JournalEntry records one RPC handled by the stub.
+type JournalEntry struct {
+	Time      time.Time      `json:"time"`              // by the stub's clock
+	Session   string         `json:"session,omitempty"` // from SessionHeader
+	Procedure string         `json:"procedure"`
+	Proxied   bool           `json:"proxied,omitempty"` // forwarded to the upstream backend
+	Header    http.Header    `json:"header"`
+	Request   proto.Message  `json:"-"`
+	Response  proto.Message  `json:"-"` // nil if the call failed
+	Err       *connect.Error `json:"-"`
+}
+
+// MarshalJSON implements json.Marshaler, writing the request and response in
+// the fixture format.
+func (e *JournalEntry) MarshalJSON() ([]byte, error) {
+	type entry JournalEntry // without the MarshalJSON method
+	out := struct {
+		*entry
+		Request  json.RawMessage `json:"request"`
+		Response json.RawMessage `json:"response,omitempty"`
+		Error    *errorJSON      `json:"error,omitempty"`
+	}{entry: (*entry)(e)}
+	var err error
+	if out.Request, err = protojson.Marshal(e.Request); err != nil {
+		return nil, err
+	}
+	if e.Response != nil {
+		if out.Response, err = protojson.Marshal(e.Response); err != nil {
+			return nil, err
+		}
+	}
+	if e.Err != nil {
+		out.Error = &errorJSON{Code: e.Err.Code(), Message: e.Err.Message()}
+	}
+	return json.Marshal(out)
+}
+
+// Journal keeps the most recent RPCs handled by the stub, so that tests can
+// check which calls were made.
+type Journal struct {
+	clock Clock
+	limit int
+
+	mu sync.Mutex
+	// entries is a ring of at most limit entries, the oldest at start.
+	entries []*JournalEntry
+	start   int
+}
+
+// NewJournal returns a Journal that timestamps entries with clock and keeps at
+// most limit of them, dropping the oldest first.  A limit of 0 keeps nothing.
+func NewJournal(clock Clock, limit int) *Journal {
+	return &Journal{clock: clock, limit: limit}
+}
+
+// Record adds an entry for a call to procedure.  err is the error the call
+// failed with, if any, and proxied whether it was forwarded upstream.
+func (j *Journal) Record(procedure string, header http.Header, req, resp proto.Message, err error, proxied bool) {
+	if j.limit <= 0 {
+		return
+	}
+	e := &JournalEntry{
+		Time:      j.clock.Now(),
+		Session:   header.Get(SessionHeader),
+		Procedure: procedure,
+		Proxied:   proxied,
+		Header:    header.Clone(),
+		Request:   proto.Clone(req),
+	}
+	if err != nil {
+		if !errors.As(err, &e.Err) {
+			e.Err = connect.NewError(connect.CodeUnknown, err)
+		}
+	} else {
+		e.Response = proto.Clone(resp)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.entries) < j.limit {
+		j.entries = append(j.entries, e)
+		return
+	}
+	j.entries[j.start] = e
+	j.start = (j.start + 1) % len(j.entries)
+}
+
+// ordered returns the entries, oldest first.  Callers must hold j.mu.
+func (j *Journal) ordered() []*JournalEntry {
+	return append(j.entries[j.start:len(j.entries):len(j.entries)], j.entries[:j.start]...)
+}
+
+// Find returns the entries, oldest first, that were made in session and match
+// pattern.  An empty session matches every session, and a nil pattern every
+// call.
+func (j *Journal) Find(session string, pattern *RequestResponseMapping) []*JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := []*JournalEntry{}
+	for _, e := range j.ordered() {
+		if session != "" && e.Session != session {
+			continue
+		}
+		if pattern != nil && (e.Procedure != pattern.Procedure || len(pattern.mismatches(e.Request)) > 0) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// Clear removes the entries made in session, or every entry if session is
+// empty.
+func (j *Journal) Clear(session string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var kept []*JournalEntry
+	if session != "" {
+		for _, e := range j.ordered() {
+			if e.Session != session {
+				kept = append(kept, e)
+			}
+		}
+	}
+	j.entries, j.start = kept, 0
+}
+
+// ParseRequestPattern decodes a pattern that selects journal entries.  It uses
+// the fields of a fixture mapping that match requests, for example:
+//
+//	procedure: /krelinga.video.in.v1.Service/ProjectAssignDiskDirs
+//	request: {project: Test}
+//	match:
+//	  - {field: dirs, contains: Disc 1}
+//
+// An empty pattern is nil, and matches every call.
+func ParseRequestPattern(b []byte) (*RequestResponseMapping, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fixtureError(root, "pattern", "expected an object")
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		switch key := root.Content[i]; key.Value {
+		case "procedure", "request", "exact", "match":
+		default:
+			return nil, fixtureError(key, "pattern."+key.Value, "unknown field")
+		}
+	}
+	return decodeMapping(root, "pattern")
+}
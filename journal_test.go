@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"buf.build/gen/go/krelinga/proto/connectrpc/go/krelinga/video/in/v1/inv1connect"
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"connectrpc.com/connect"
+)
+
+func TestJournal(t *testing.T) {
+	store := NewModelStore(data.Clone(), nil)
+	journal := NewJournal(store.Clock(), 100)
+	_, handler := inv1connect.NewServiceHandler(
+		NewStubService(store),
+		connect.WithInterceptors(&LoggingInterceptor{Journal: journal}, &SessionInterceptor{Store: store}),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	admin := httptest.NewServer(NewAdminHandler(store, "", &FaultInterceptor{}, journal, nil))
+	defer admin.Close()
+
+	client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	ctx := context.Background()
+	get := func(session, project string) {
+		req := connect.NewRequest(&v1.ProjectGetRequest{Project: project})
+		req.Header().Set(SessionHeader, session)
+		client.ProjectGet(ctx, req)
+	}
+	get("a", "Name With Spaces")
+	get("a", "Missing")
+	get("b", "Name With Spaces")
+	client.ProjectList(ctx, connect.NewRequest(&v1.ProjectListRequest{}))
+
+	do := func(method, path, session, body string) string {
+		t.Helper()
+		req, err := http.NewRequest(method, admin.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if session != "" {
+			req.Header.Set(SessionHeader, session)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode/100 != 2 {
+			t.Fatalf("Expected %s %s to succeed, got %d: %s", method, path, resp.StatusCode, b)
+		}
+		return string(b)
+	}
+	type entry struct {
+		Session   string          `json:"session"`
+		Procedure string          `json:"procedure"`
+		Request   json.RawMessage `json:"request"`
+		Response  json.RawMessage `json:"response"`
+		Error     *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	entries := func(method, path, session, body string) []entry {
+		t.Helper()
+		var out []entry
+		if err := json.Unmarshal([]byte(do(method, path, session, body)), &out); err != nil {
+			t.Fatalf("Failed to decode entries: %v", err)
+		}
+		return out
+	}
+	count := func(session, pattern string) int {
+		t.Helper()
+		var out struct{ Count int }
+		if err := json.Unmarshal([]byte(do(http.MethodPost, "/admin/journal/count", session, pattern)), &out); err != nil {
+			t.Fatalf("Failed to decode count: %v", err)
+		}
+		return out.Count
+	}
+
+	if got := entries(http.MethodGet, "/admin/journal", "", ""); len(got) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(got))
+	}
+	got := entries(http.MethodGet, "/admin/journal?procedure="+inv1connect.ServiceProjectGetProcedure, "a", "")
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 ProjectGet calls in session a, got %d", len(got))
+	}
+	if e := got[0]; e.Session != "a" || !strings.Contains(string(e.Request), "Name With Spaces") || !strings.Contains(string(e.Response), "discs") {
+		t.Errorf("Unexpected first entry: %+v", e)
+	}
+	if e := got[1]; e.Error == nil || e.Error.Code != "not_found" || e.Response != nil {
+		t.Errorf("Expected a not_found entry, got %+v", e)
+	}
+
+	pattern := `{procedure: /krelinga.video.in.v1.Service/ProjectGet, request: {project: Name With Spaces}}`
+	if got := count("", pattern); got != 2 {
+		t.Errorf("Expected 2 matching calls, got %d", got)
+	}
+	if got := count("b", pattern); got != 1 {
+		t.Errorf("Expected 1 matching call in session b, got %d", got)
+	}
+	if got := entries(http.MethodPost, "/admin/journal/find", "", `
+procedure: /krelinga.video.in.v1.Service/ProjectGet
+match: [{field: project, prefix: Miss}]
+`); len(got) != 1 || got[0].Session != "a" {
+		t.Errorf("Expected the call for Missing, got %+v", got)
+	}
+	if got := count("", ""); got != 4 {
+		t.Errorf("Expected an empty pattern to match all 4 calls, got %d", got)
+	}
+
+	// Clearing a session only forgets its own calls.
+	do(http.MethodDelete, "/admin/journal", "a", "")
+	if got := count("", ""); got != 2 {
+		t.Errorf("Expected 2 calls after clearing session a, got %d", got)
+	}
+	do(http.MethodDelete, "/admin/journal", "", "")
+	if got := count("", ""); got != 0 {
+		t.Errorf("Expected no calls after clearing the journal, got %d", got)
+	}
+
+	for _, bad := range []string{`{procedure: Nope}`, `{procedure: /krelinga.video.in.v1.Service/ProjectGet, response: {}}`} {
+		resp, err := http.Post(admin.URL+"/admin/journal/count", "", strings.NewReader(bad))
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected pattern %s to be rejected, got %d", bad, resp.StatusCode)
+		}
+	}
+}
+
+func TestJournalLimit(t *testing.T) {
+	journal := NewJournal(&VirtualClock{}, 2)
+	for _, name := range []string{"a", "b", "c"} {
+		journal.Record(inv1connect.ServiceHelloWorldProcedure, http.Header{}, &v1.HelloWorldRequest{Name: name}, &v1.HelloWorldResponse{}, nil, false)
+	}
+	names := func() []string {
+		var out []string
+		for _, e := range journal.Find("", nil) {
+			out = append(out, e.Request.(*v1.HelloWorldRequest).Name)
+		}
+		return out
+	}
+	if got, want := names(), []string{"b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("Expected the 2 most recent calls %v, got %v", want, got)
+	}
+
+	// Clearing a session keeps the order of the rest.
+	journal.Record(inv1connect.ServiceHelloWorldProcedure, http.Header{SessionHeader: {"s"}}, &v1.HelloWorldRequest{Name: "d"}, &v1.HelloWorldResponse{}, nil, false)
+	journal.Record(inv1connect.ServiceHelloWorldProcedure, http.Header{}, &v1.HelloWorldRequest{Name: "e"}, &v1.HelloWorldResponse{}, nil, false)
+	journal.Clear("s")
+	if got, want := names(), []string{"e"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v after clearing the session, got %v", want, got)
+	}
+	journal.Record(inv1connect.ServiceHelloWorldProcedure, http.Header{}, &v1.HelloWorldRequest{Name: "f"}, &v1.HelloWorldResponse{}, nil, false)
+	if got, want := names(), []string{"e", "f"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// A limit of 0 disables the journal.
+	journal = NewJournal(&VirtualClock{}, 0)
+	journal.Record(inv1connect.ServiceHelloWorldProcedure, http.Header{}, &v1.HelloWorldRequest{Name: "a"}, &v1.HelloWorldResponse{}, nil, false)
+	if got := names(); len(got) != 0 {
+		t.Errorf("Expected no calls to be kept, got %v", got)
+	}
+}
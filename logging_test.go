@@ -3,28 +3,46 @@ package main
 import (
 	"bytes"
 	"context"
-	"log"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
-	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
 	"buf.build/gen/go/krelinga/proto/connectrpc/go/krelinga/video/in/v1/inv1connect"
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
 	"connectrpc.com/connect"
 )
 
+// logRecords decodes the JSON log records in buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("Failed to decode log record %q: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
 func TestLoggingInterceptor(t *testing.T) {
-	// Capture log output using a buffer as the log output
+	// Capture log output as JSON records
 	var buf bytes.Buffer
-	originalOutput := log.Writer()
-	log.SetOutput(&buf)
-	defer log.SetOutput(originalOutput)
+	logger, err := newLogger(&buf, "json", "info")
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
 
 	// Create service with logging interceptor
-	service := NewStubService()
-	loggingInterceptor := &LoggingInterceptor{}
-	
+	service := NewStubService(NewModelStore(data.Clone(), nil))
+	loggingInterceptor := &LoggingInterceptor{Logger: logger}
+
 	// Create handler with interceptor
 	_, handler := inv1connect.NewServiceHandler(
 		service,
@@ -41,29 +59,46 @@ func TestLoggingInterceptor(t *testing.T) {
 
 	// Test successful request
 	req := connect.NewRequest(&v1.HelloWorldRequest{Name: "test"})
+	req.Header().Set(SessionHeader, "s1")
 	resp, err := client.HelloWorld(ctx, req)
-	
+
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
-	
+
 	if resp == nil {
 		t.Fatal("Expected non-nil response")
 	}
 
 	// Verify logging occurred
-	logOutput := buf.String()
-	if !strings.Contains(logOutput, "RPC Call") {
-		t.Errorf("Expected log output to contain 'RPC Call', got: %s", logOutput)
+	records := logRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 log record, got %d: %s", len(records), buf.String())
+	}
+	r := records[0]
+	for key, want := range map[string]any{
+		"msg":       "RPC Call",
+		"level":     "INFO",
+		"procedure": inv1connect.ServiceHelloWorldProcedure,
+		"source":    "stubbed",
+		"session":   "s1",
+		"code":      "ok",
+	} {
+		if r[key] != want {
+			t.Errorf("Expected %s to be %v, got %v", key, want, r[key])
+		}
+	}
+	if _, ok := r["duration"].(float64); !ok {
+		t.Errorf("Expected a duration, got %v", r["duration"])
 	}
-	if !strings.Contains(logOutput, "HelloWorld") {
-		t.Errorf("Expected log output to contain method name 'HelloWorld', got: %s", logOutput)
+	if peer, _ := r["peer"].(string); peer == "" {
+		t.Errorf("Expected the peer address, got %v", r["peer"])
 	}
-	if !strings.Contains(logOutput, "test") {
-		t.Errorf("Expected log output to contain request data 'test', got: %s", logOutput)
+	if got, _ := r["request"].(map[string]any); got["name"] != "test" {
+		t.Errorf("Expected the request as an object with name test, got %v", r["request"])
 	}
-	if !strings.Contains(logOutput, "Hello, test!") {
-		t.Errorf("Expected log output to contain response data 'Hello, test!', got: %s", logOutput)
+	if got, _ := r["response"].(map[string]any); got["message"] != "Hello, test!" {
+		t.Errorf("Expected the response as an object with message 'Hello, test!', got %v", r["response"])
 	}
 
 	// Reset buffer for error test
@@ -72,17 +107,148 @@ func TestLoggingInterceptor(t *testing.T) {
 	// Test error case
 	errorReq := connect.NewRequest(&v1.HelloWorldRequest{Name: "unknown"})
 	_, err = client.HelloWorld(ctx, errorReq)
-	
+
 	if err == nil {
 		t.Fatal("Expected error for unknown request")
 	}
 
 	// Verify error logging
-	errorLogOutput := buf.String()
-	if !strings.Contains(errorLogOutput, "RPC Call") {
-		t.Errorf("Expected error log output to contain 'RPC Call', got: %s", errorLogOutput)
+	records = logRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 log record, got %d: %s", len(records), buf.String())
+	}
+	r = records[0]
+	if r["level"] != "WARN" || r["code"] != "not_found" {
+		t.Errorf("Expected a WARN record with code not_found, got %v", r)
+	}
+	if msg, _ := r["error"].(string); !strings.Contains(msg, "no matching request found") {
+		t.Errorf("Expected the error message, got %v", r["error"])
+	}
+	if _, ok := r["response"]; ok {
+		t.Errorf("Expected no response for a failed call, got %v", r["response"])
+	}
+	if _, ok := r["session"]; ok {
+		t.Errorf("Expected no session without the header, got %v", r["session"])
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "text", "warn")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown", "request", payload(`{"name":"test"}`))
+	if got := buf.String(); strings.Contains(got, "hidden") || !strings.Contains(got, `request="{\"name\":\"test\"}"`) {
+		t.Errorf("Expected only the warning, with the request as a string, got: %s", got)
+	}
+
+	for _, tt := range []struct{ format, level string }{{"xml", "info"}, {"text", "loud"}} {
+		if _, err := newLogger(&buf, tt.format, tt.level); err == nil {
+			t.Errorf("Expected an error for format %q and level %q", tt.format, tt.level)
+		}
+	}
+}
+
+func TestLoggingRedactsErrors(t *testing.T) {
+	m, err := ParseFixture([]byte(`
+mappings:
+  - procedure: /krelinga.video.in.v1.Service/ProjectAssignDiskDirs
+    request: {project: Mapped, dirs: [Other Dir]}
+    response: {}
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store := NewModelStore(m, nil)
+	for _, tt := range []struct {
+		name   string
+		opts   PayloadOptions
+		replay bool // strictly replay nothing, so the error has the request JSON
+		secret string
+	}{
+		{name: "redacted field", opts: PayloadOptions{Redact: []string{"project"}}, secret: "Pname"},
+		{name: "redacted field in JSON", opts: PayloadOptions{Redact: []string{"project"}}, replay: true, secret: "Pname"},
+		{name: "redacted list", opts: PayloadOptions{Mode: PayloadSummary, Redact: []string{"dirs"}}, replay: true, secret: "Dname"},
+		{name: "no payloads", opts: PayloadOptions{Mode: PayloadNone, Redact: []string{"project"}}, secret: "Pname"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := newLogger(&buf, "json", "info")
+			if err != nil {
+				t.Fatalf("Failed to create logger: %v", err)
+			}
+			s := NewStubService(store)
+			if tt.replay {
+				s.replay = NewReplay(nil)
+				s.replay.Strict = true
+			}
+			_, handler := inv1connect.NewServiceHandler(
+				s,
+				connect.WithInterceptors(&LoggingInterceptor{Logger: logger, Payloads: tt.opts}, &SessionInterceptor{Store: store}),
+			)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			// The call misses the replay, or the mapping and the model, and its
+			// error quotes the request.
+			client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+			_, err = client.ProjectAssignDiskDirs(context.Background(), connect.NewRequest(&v1.ProjectAssignDiskDirsRequest{
+				Project: `Secret "Pname"`,
+				Dirs:    []string{"Dname"},
+			}))
+			if err == nil || !strings.Contains(err.Error(), tt.secret) {
+				t.Fatalf("Expected an error quoting the request, got: %v", err)
+			}
+			records := logRecords(t, &buf)
+			if len(records) != 1 {
+				t.Fatalf("Expected 1 log record, got %d: %s", len(records), buf.String())
+			}
+			if strings.Contains(buf.String(), tt.secret) {
+				t.Errorf("Expected %s never to be logged, got: %s", tt.secret, buf.String())
+			}
+			if msg, _ := records[0]["error"].(string); !strings.Contains(msg, "[REDACTED]") {
+				t.Errorf("Expected the error to be logged redacted, got %q", msg)
+			}
+		})
+	}
+}
+
+func TestLoggingDroppedConnection(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", "info")
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	store := NewModelStore(data.Clone(), nil)
+	journal := NewJournal(store.Clock(), 10)
+	faults := &FaultInterceptor{}
+	faults.SetRules([]FaultRule{{Procedure: "*", Close: true}})
+	_, handler := inv1connect.NewServiceHandler(
+		NewStubService(store),
+		connect.WithInterceptors(&LoggingInterceptor{Logger: logger, Journal: journal}, faults, &SessionInterceptor{Store: store}),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	if _, err := client.ProjectList(context.Background(), connect.NewRequest(&v1.ProjectListRequest{})); err == nil {
+		t.Fatal("Expected the dropped call to fail")
+	}
+
+	// The call is logged and journaled like any other before it is dropped.
+	// Closing the server waits for the handler to finish.
+	server.Close()
+	records := logRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 log record, got %d: %s", len(records), buf.String())
+	}
+	if r := records[0]; r["procedure"] != inv1connect.ServiceProjectListProcedure || r["code"] != "unavailable" {
+		t.Errorf("Expected an unavailable record for ProjectList, got %v", r)
 	}
-	if !strings.Contains(errorLogOutput, "Error") {
-		t.Errorf("Expected error log output to contain 'Error', got: %s", errorLogOutput)
+	entries := journal.Find("", nil)
+	if len(entries) != 1 || entries[0].Err == nil || entries[0].Err.Code() != connect.CodeUnavailable {
+		t.Errorf("Expected 1 journal entry with an unavailable error, got %v", entries)
 	}
-}
\ No newline at end of file
+}
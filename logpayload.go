@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"buf.build/gen/go/krelinga/proto/connectrpc/go/krelinga/video/in/v1/inv1connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// PayloadMode is how much of each request and response LoggingInterceptor
+// logs.
+type PayloadMode string
+
+const (
+	PayloadFull    PayloadMode = "full"    // the whole message; the default
+	PayloadSummary PayloadMode = "summary" // the message with repeated and map fields replaced by their sizes
+	PayloadNone    PayloadMode = "none"    // nothing
+)
+
+// ParsePayloadMode returns the PayloadMode named s.
+func ParsePayloadMode(s string) (PayloadMode, error) {
+	switch m := PayloadMode(s); m {
+	case PayloadFull, PayloadSummary, PayloadNone:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown payload mode %q, want full, summary or none", s)
+}
+
+// payload is a message in JSON, logged as a nested object by JSON handlers
+// and as a string by text handlers.
+type payload []byte
+
+func (p payload) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("null"), nil
+	}
+	return p, nil
+}
+
+func (p payload) MarshalText() ([]byte, error) {
+	return p, nil
+}
+
+// redacted replaces the value of each redacted field.
+const redacted = "[REDACTED]"
+
+// PayloadOptions controls how LoggingInterceptor logs request and response
+// messages.  The zero value logs them in full.
+type PayloadOptions struct {
+	Mode PayloadMode
+
+	// Redact lists the paths of fields whose values are replaced with
+	// "[REDACTED]", such as "discs.discFiles".  Each path is the names of the
+	// fields from the request or response message down, in either their JSON
+	// or proto form, and applies to every element of the lists it goes
+	// through.
+	Redact []string
+
+	// MaxBytes is the most bytes of JSON to log for each message; longer
+	// messages are cut short and logged as a string.  0 means no limit.
+	MaxBytes int
+}
+
+// value returns msg as it should be logged, or a null payload if msg is not a
+// proto message.
+func (o PayloadOptions) value(msg any) slog.Value {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return slog.AnyValue(payload(nil))
+	}
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		return slog.AnyValue(payload(nil))
+	}
+	if len(o.Redact) > 0 || o.Mode == PayloadSummary {
+		var v any
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return slog.AnyValue(payload(nil))
+		}
+		v = o.shape(v, m.ProtoReflect().Descriptor(), nil)
+		if b, err = json.Marshal(v); err != nil {
+			return slog.AnyValue(payload(nil))
+		}
+	}
+	if o.MaxBytes > 0 && len(b) > o.MaxBytes {
+		cut := o.MaxBytes
+		for cut > 0 && !utf8.RuneStart(b[cut]) {
+			cut--
+		}
+		return slog.StringValue(fmt.Sprintf("%s... (truncated, %d bytes)", b[:cut], len(b)))
+	}
+	return slog.AnyValue(payload(b))
+}
+
+// shape applies the redaction rules and summary mode to v, the JSON form of
+// a message of type md, whose fields are at path.
+func (o PayloadOptions) shape(v any, md protoreflect.MessageDescriptor, path []protoreflect.FieldDescriptor) any {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		// Well-known types such as Timestamp have their own JSON forms.
+		return v
+	}
+	for key, fv := range obj {
+		fd := md.Fields().ByJSONName(key)
+		if fd == nil {
+			continue
+		}
+		fpath := append(path[:len(path):len(path)], fd)
+		switch {
+		case o.redacts(fpath):
+			obj[key] = redacted
+		case o.Mode == PayloadSummary && fd.IsList():
+			list, _ := fv.([]any)
+			obj[key] = fmt.Sprintf("[%d items]", len(list))
+		case o.Mode == PayloadSummary && fd.IsMap():
+			entries, _ := fv.(map[string]any)
+			obj[key] = fmt.Sprintf("[%d entries]", len(entries))
+		case fd.IsList() && fd.Message() != nil:
+			list, _ := fv.([]any)
+			for i, elem := range list {
+				list[i] = o.shape(elem, fd.Message(), fpath)
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			entries, _ := fv.(map[string]any)
+			for k, elem := range entries {
+				entries[k] = o.shape(elem, fd.MapValue().Message(), fpath)
+			}
+		case fd.Message() != nil && !fd.IsMap():
+			obj[key] = o.shape(fv, fd.Message(), fpath)
+		}
+	}
+	return obj
+}
+
+// redacts reports whether the field at path is redacted.
+func (o PayloadOptions) redacts(path []protoreflect.FieldDescriptor) bool {
+	for _, r := range o.Redact {
+		names := strings.Split(r, ".")
+		if len(names) != len(path) {
+			continue
+		}
+		match := true
+		for i, fd := range path {
+			if names[i] != fd.JSONName() && names[i] != string(fd.Name()) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// errorText returns the message of err, which a call with request req failed
+// with, as it should be logged.  Error messages often quote the request, so
+// the string values of the redacted fields of req are replaced with
+// "[REDACTED]" where they appear whole: in quotes, or between characters that
+// cannot be part of a word.
+func (o PayloadOptions) errorText(err error, req any) string {
+	text := err.Error()
+	m, ok := req.(proto.Message)
+	if !ok || len(o.Redact) == 0 {
+		return text
+	}
+	var hidden []string
+	o.redactedStrings(m.ProtoReflect(), nil, false, &hidden)
+	// Replace longer values first, so that their substrings don't break them up.
+	slices.SortFunc(hidden, func(a, b string) int { return cmp.Compare(len(b), len(a)) })
+	for _, v := range hidden {
+		quoted := strconv.Quote(v)
+		escaped, _ := json.Marshal(v)
+		text = strings.ReplaceAll(text, quoted, strconv.Quote(redacted))
+		text = strings.ReplaceAll(text, string(escaped), strconv.Quote(redacted))
+		text = replaceWhole(text, v, redacted)
+	}
+	return text
+}
+
+// replaceWhole replaces the occurrences of old in s that are not part of a
+// longer word with new.
+func replaceWhole(s, old, new string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, old)
+		if i < 0 {
+			break
+		}
+		end := i + len(old)
+		before, _ := utf8.DecodeLastRuneInString(s[:i])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		first, _ := utf8.DecodeRuneInString(old)
+		last, _ := utf8.DecodeLastRuneInString(old)
+		if (i > 0 && isWordRune(first) && isWordRune(before)) || (end < len(s) && isWordRune(last) && isWordRune(after)) {
+			b.WriteString(s[:i+1])
+			s = s[i+1:]
+			continue
+		}
+		b.WriteString(s[:i])
+		b.WriteString(new)
+		s = s[end:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// redactedStrings appends to out the string values in msg, whose fields are
+// at path, that are redacted.  If hide is set, all of them are.
+func (o PayloadOptions) redactedStrings(msg protoreflect.Message, path []protoreflect.FieldDescriptor, hide bool, out *[]string) {
+	add := func(fd protoreflect.FieldDescriptor, v protoreflect.Value) {
+		if fd.Kind() == protoreflect.StringKind && v.String() != "" {
+			*out = append(*out, v.String())
+		}
+	}
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		fpath := append(path[:len(path):len(path)], fd)
+		hide := hide || o.redacts(fpath)
+		switch {
+		case fd.IsList():
+			list := v.List()
+			for i := range list.Len() {
+				if fd.Message() != nil {
+					o.redactedStrings(list.Get(i).Message(), fpath, hide, out)
+				} else if hide {
+					add(fd, list.Get(i))
+				}
+			}
+		case fd.IsMap():
+			v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				if hide {
+					add(fd.MapKey(), k.Value())
+				}
+				if fd.MapValue().Message() != nil {
+					o.redactedStrings(mv.Message(), fpath, hide, out)
+				} else if hide {
+					add(fd.MapValue(), mv)
+				}
+				return true
+			})
+		case fd.Message() != nil:
+			o.redactedStrings(v.Message(), fpath, hide, out)
+		case hide:
+			add(fd, v)
+		}
+		return true
+	})
+}
+
+// CheckRedact returns an error if any of the Redact paths names no field of
+// the requests or responses of the service, so that a mistyped path does not
+// leave the field it meant to hide in the logs.
+func (o PayloadOptions) CheckRedact() error {
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(inv1connect.ServiceName)
+	if err != nil {
+		return err
+	}
+	methods := d.(protoreflect.ServiceDescriptor).Methods()
+	var errs []error
+	for _, r := range o.Redact {
+		found := false
+		for i := range methods.Len() {
+			md := methods.Get(i)
+			if resolvesIn(md.Input(), r) || resolvesIn(md.Output(), r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("%q names no field of any request or response", r))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// resolvesIn reports whether path names a field of md, by the JSON or proto
+// names of the fields down to it.
+func resolvesIn(md protoreflect.MessageDescriptor, path string) bool {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		if md == nil {
+			return false
+		}
+		fd := md.Fields().ByJSONName(name)
+		if fd == nil {
+			fd = md.Fields().ByName(protoreflect.Name(name))
+		}
+		if fd == nil {
+			return false
+		}
+		if i == len(names)-1 {
+			return true
+		}
+		md = fd.Message()
+		if fd.IsMap() {
+			md = fd.MapValue().Message()
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+)
+
+func TestPayloadOptions(t *testing.T) {
+	msg := &v1.ProjectGetResponse{
+		Project: "Test",
+		Discs: []*v1.ProjectDisc{
+			{Disc: "Disc 1", ThumbState: "done", DiscFiles: []*v1.DiscFile{{File: "a.mkv"}, {File: "b.mkv"}}},
+			{Disc: "Disc 2", DiscFiles: []*v1.DiscFile{{File: "c.mkv"}}},
+		},
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts PayloadOptions
+		want string
+	}{
+		{
+			name: "full",
+			want: `{"project":"Test","discs":[{"disc":"Disc 1","thumbState":"done","discFiles":[{"file":"a.mkv"},{"file":"b.mkv"}]},{"disc":"Disc 2","discFiles":[{"file":"c.mkv"}]}]}`,
+		},
+		{
+			name: "summary",
+			opts: PayloadOptions{Mode: PayloadSummary},
+			want: `{"project":"Test","discs":"[2 items]"}`,
+		},
+		{
+			name: "redact by JSON name",
+			opts: PayloadOptions{Redact: []string{"discs.discFiles", "project"}},
+			want: `{"project":"[REDACTED]","discs":[{"disc":"Disc 1","thumbState":"done","discFiles":"[REDACTED]"},{"disc":"Disc 2","discFiles":"[REDACTED]"}]}`,
+		},
+		{
+			name: "redact by proto name",
+			opts: PayloadOptions{Redact: []string{"discs.thumb_state", "discs.disc_files.file"}},
+			want: `{"project":"Test","discs":[{"disc":"Disc 1","thumbState":"[REDACTED]","discFiles":[{"file":"[REDACTED]"},{"file":"[REDACTED]"}]},{"disc":"Disc 2","discFiles":[{"file":"[REDACTED]"}]}]}`,
+		},
+		{
+			name: "redaction only matches whole paths",
+			opts: PayloadOptions{Redact: []string{"discFiles", "discs.disc.file"}},
+			want: `{"project":"Test","discs":[{"disc":"Disc 1","thumbState":"done","discFiles":[{"file":"a.mkv"},{"file":"b.mkv"}]},{"disc":"Disc 2","discFiles":[{"file":"c.mkv"}]}]}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.opts.value(msg).Any())
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			// Compare after decoding, since protojson does not promise stable output.
+			var got, want any
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("Failed to decode %s: %v", b, err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatalf("Failed to decode %s: %v", tt.want, err)
+			}
+			gb, _ := json.Marshal(got)
+			wb, _ := json.Marshal(want)
+			if string(gb) != string(wb) {
+				t.Errorf("Expected %s, got %s", wb, gb)
+			}
+		})
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		v := PayloadOptions{MaxBytes: 20}.value(msg)
+		head, _, ok := strings.Cut(v.String(), "... (truncated, ")
+		if !ok || len(head) > 20 || !strings.HasPrefix(head, `{"project":"Test"`) {
+			t.Errorf("Expected the payload cut to 20 bytes, got %q", v.String())
+		}
+		if v := (PayloadOptions{MaxBytes: 1000}).value(msg); v.Kind() != slog.KindAny {
+			t.Errorf("Expected a short payload to be logged whole, got %q", v.String())
+		}
+	})
+}
+
+func TestParsePayloadMode(t *testing.T) {
+	if m, err := ParsePayloadMode("summary"); err != nil || m != PayloadSummary {
+		t.Errorf("Expected summary, got %q, %v", m, err)
+	}
+	if _, err := ParsePayloadMode("brief"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
+
+func TestCheckRedact(t *testing.T) {
+	valid := PayloadOptions{Redact: []string{"project", "discs.discFiles", "discs.disc_files.human_size", "dirs", "searchResult.posterUrl"}}
+	if err := valid.CheckRedact(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for _, path := range []string{"Project", "discs.discfiles", "discs.nope", "project.name", ""} {
+		err := PayloadOptions{Redact: []string{"project", path}}.CheckRedact()
+		if err == nil || !strings.Contains(err.Error(), strconv.Quote(path)) {
+			t.Errorf("Expected an error naming %q, got: %v", path, err)
+		}
+	}
+}
+
+func TestErrorText(t *testing.T) {
+	req := &v1.ProjectAssignDiskDirsRequest{Project: "e", Dirs: []string{"i"}}
+	for _, tt := range []struct {
+		name string
+		opts PayloadOptions
+		err  string
+		want string
+	}{
+		{
+			name: "short value",
+			opts: PayloadOptions{Redact: []string{"project"}},
+			err:  "project not found: e",
+			want: "project not found: [REDACTED]",
+		},
+		{
+			name: "quoted",
+			opts: PayloadOptions{Redact: []string{"dirs"}},
+			err:  `dir "i" is claimed; request {"project":"e","dirs":["i"]}`,
+			want: `dir "[REDACTED]" is claimed; request {"project":"e","dirs":["[REDACTED]"]}`,
+		},
+		{
+			name: "unredacted values are kept",
+			opts: PayloadOptions{Mode: PayloadNone},
+			err:  "project not found: e",
+			want: "project not found: e",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.errorText(errors.New(tt.err), req); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
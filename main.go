@@ -2,22 +2,36 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"math/rand/v2"
 	"net/http"
-	"slices"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"buf.build/gen/go/krelinga/proto/connectrpc/go/krelinga/video/in/v1/inv1connect"
 	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
 	"connectrpc.com/connect"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
-	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
 
-// LoggingInterceptor implements connect.Interceptor to log all RPC calls
-type LoggingInterceptor struct{}
+// LoggingInterceptor implements connect.Interceptor to log all RPC calls as
+// structured records, noting whether each was stubbed or proxied upstream,
+// and record them in Journal if it is set
+type LoggingInterceptor struct {
+	Logger   *slog.Logger // defaults to slog.Default()
+	Payloads PayloadOptions
+	Journal  *Journal
+}
 
 // WrapUnary implements the Interceptor interface for unary RPC calls
 func (l *LoggingInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
@@ -25,39 +39,83 @@ func (l *LoggingInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc
 		// Get the procedure name from the request
 		procedure := req.Spec().Procedure
 
-		// Convert request message to JSON for logging
-		reqJSON := ""
-		if req.Any() != nil {
-			if msg, ok := req.Any().(proto.Message); ok {
-				if jsonBytes, err := protojson.Marshal(msg); err == nil {
-					reqJSON = string(jsonBytes)
-				}
+		// Call the actual handler
+		ctx, source := withCallSource(ctx)
+		start := time.Now()
+		resp, aborted, err := callRecovering(ctx, next, req)
+		duration := time.Since(start)
+		if aborted {
+			// Log and journal a dropped connection before passing the
+			// abort on to net/http.
+			defer panic(http.ErrAbortHandler)
+		}
+
+		if l.Journal != nil {
+			var reqMsg, respMsg proto.Message
+			reqMsg, _ = req.Any().(proto.Message)
+			if err == nil && resp != nil {
+				respMsg, _ = resp.Any().(proto.Message)
 			}
+			l.Journal.Record(procedure, req.Header(), reqMsg, respMsg, err, source.proxied)
 		}
 
-		// Call the actual handler
-		resp, err := next(ctx, req)
+		logger := l.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		attrs := []slog.Attr{
+			slog.String("procedure", procedure),
+			slog.String("source", source.String()),
+			slog.Duration("duration", duration),
+			slog.String("peer", req.Peer().Addr),
+		}
+		if session := req.Header().Get(SessionHeader); session != "" {
+			attrs = append(attrs, slog.String("session", session))
+		}
+		logPayloads := l.Payloads.Mode != PayloadNone
+		if logPayloads {
+			attrs = append(attrs, slog.Attr{Key: "request", Value: l.Payloads.value(req.Any())})
+		}
 
 		// Log the RPC call with error handling
+		level := slog.LevelInfo
 		if err != nil {
-			log.Printf("RPC Call [%s] - Request: %s - Error: %v", procedure, reqJSON, err)
+			level = slog.LevelWarn
+			attrs = append(attrs,
+				slog.String("code", connect.CodeOf(err).String()),
+				slog.String("error", l.Payloads.errorText(err, req.Any())))
 		} else {
-			// Convert response message to JSON for logging (if successful)
-			respJSON := ""
-			if resp != nil && resp.Any() != nil {
-				if msg, ok := resp.Any().(proto.Message); ok {
-					if jsonBytes, errJSON := protojson.Marshal(msg); errJSON == nil {
-						respJSON = string(jsonBytes)
-					}
-				}
+			attrs = append(attrs, slog.String("code", "ok"))
+			if logPayloads && resp != nil {
+				attrs = append(attrs, slog.Attr{Key: "response", Value: l.Payloads.value(resp.Any())})
 			}
-			log.Printf("RPC Call [%s] - Request: %s - Response: %s", procedure, reqJSON, respJSON)
 		}
+		logger.LogAttrs(ctx, level, "RPC Call", attrs...)
 
 		return resp, err
 	}
 }
 
+// errConnectionClosed is logged and journaled for a call whose connection
+// was dropped instead of answered.
+var errConnectionClosed = connect.NewError(connect.CodeUnavailable, errors.New("connection closed without a response"))
+
+// callRecovering calls next, and reports whether it aborted the response by
+// panicking with http.ErrAbortHandler, as FaultInterceptor does to drop the
+// connection.  Any other panic is passed on.
+func callRecovering(ctx context.Context, next connect.UnaryFunc, req connect.AnyRequest) (resp connect.AnyResponse, aborted bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r != http.ErrAbortHandler {
+				panic(r)
+			}
+			resp, aborted, err = nil, true, errConnectionClosed
+		}
+	}()
+	resp, err = next(ctx, req)
+	return resp, false, err
+}
+
 // WrapStreamingClient implements the Interceptor interface for streaming client calls
 func (l *LoggingInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
 	return next // No streaming clients in this stub service
@@ -68,136 +126,360 @@ func (l *LoggingInterceptor) WrapStreamingHandler(next connect.StreamingHandlerF
 	return next // No streaming handlers in this stub service
 }
 
-// RequestResponseMapping represents a mapping between a request and its corresponding response
-type RequestResponseMapping[Req, Resp proto.Message] struct {
-	Request  Req
-	Response Resp
-}
-
 // StubService implements the ServiceHandler interface with configurable responses
 type StubService struct {
-	// Mappings for each RPC method
-	helloWorldMappings             []RequestResponseMapping[*v1.HelloWorldRequest, *v1.HelloWorldResponse]
+	// Mappings for any RPC method, consulted after those of the model
+	mappings                       []*RequestResponseMapping
+
+	// Recording to answer calls from before the mappings, if set
+	replay *Replay
+
+	store *ModelStore
 }
 
-// findMatchingResponse searches for a matching request and returns the corresponding response
-func findMatchingResponse[Req, Resp proto.Message](req Req, mappings []RequestResponseMapping[Req, Resp]) (Resp, error) {
-	for _, mapping := range mappings {
-		if proto.Equal(req, mapping.Request) {
-			return mapping.Response, nil
-		}
+// model returns the model a request should be served from: the one stored in
+// ctx by SessionInterceptor, or the base model if there is none.
+func (s *StubService) model(ctx context.Context) *Model {
+	if m := modelFromContext(ctx); m != nil {
+		return m
 	}
-	var zero Resp
-	return zero, connect.NewError(connect.CodeNotFound, fmt.Errorf("no matching request found"))
+	return s.store.Base()
 }
 
 // HelloWorld searches for a matching request and returns the corresponding response
 func (s *StubService) HelloWorld(ctx context.Context, req *connect.Request[v1.HelloWorldRequest]) (*connect.Response[v1.HelloWorldResponse], error) {
-	resp, err := findMatchingResponse(req.Msg, s.helloWorldMappings)
-	if err != nil {
-		return nil, err
+	if resp, err := findMatchingResponse[v1.HelloWorldResponse](ctx, s, req); resp != nil || err != nil {
+		return resp, err
 	}
-	return connect.NewResponse(resp), nil
+	return nil, s.explainMiss(ctx, req, connect.NewError(connect.CodeNotFound, fmt.Errorf("no matching request found")))
 }
 
 // ProjectList searches for a matching request and returns the corresponding response
-func (*StubService) ProjectList(ctx context.Context, req *connect.Request[v1.ProjectListRequest]) (*connect.Response[v1.ProjectListResponse], error) {
-	resp := &v1.ProjectListResponse{}
-	for _, p := range data.Projects {
-		resp.Projects = append(resp.Projects, p.Project)
+func (s *StubService) ProjectList(ctx context.Context, req *connect.Request[v1.ProjectListRequest]) (*connect.Response[v1.ProjectListResponse], error) {
+	if resp, err := findMatchingResponse[v1.ProjectListResponse](ctx, s, req); resp != nil || err != nil {
+		return resp, err
 	}
+	resp := &v1.ProjectListResponse{}
+	resp.Projects = s.model(ctx).ProjectNames()
 	return connect.NewResponse(resp), nil
 }
 
-// ProjectNew searches for a matching request and returns the corresponding response
+// ProjectNew creates a new, empty project in the model
 func (s *StubService) ProjectNew(ctx context.Context, req *connect.Request[v1.ProjectNewRequest]) (*connect.Response[v1.ProjectNewResponse], error) {
-	return nil, connect.NewError(connect.CodeUnimplemented, fmt.Errorf("ProjectNew is not implemented"))
+	if resp, err := findMatchingResponse[v1.ProjectNewResponse](ctx, s, req); resp != nil || err != nil {
+		return resp, err
+	}
+	if err := s.model(ctx).NewProject(req.Msg.Name); err != nil {
+		return nil, s.explainMiss(ctx, req, err)
+	}
+	return connect.NewResponse(&v1.ProjectNewResponse{}), nil
 }
 
 // UnclaimedDiscDirList searches for a matching request and returns the corresponding response
 func (s *StubService) UnclaimedDiscDirList(ctx context.Context, req *connect.Request[v1.UnclaimedDiscDirListRequest]) (*connect.Response[v1.UnclaimedDiscDirListResponse], error) {
+	if resp, err := findMatchingResponse[v1.UnclaimedDiscDirListResponse](ctx, s, req); resp != nil || err != nil {
+		return resp, err
+	}
 	resp := &v1.UnclaimedDiscDirListResponse{}
-	resp.Dirs = append(resp.Dirs, data.Unclaimed...)
+	resp.Dirs = s.model(ctx).UnclaimedDirs()
 	return connect.NewResponse(resp), nil
 }
 
-// ProjectAssignDiskDirs searches for a matching request and returns the corresponding response
+// ProjectAssignDiskDirs moves unclaimed disc directories into a project
 func (s *StubService) ProjectAssignDiskDirs(ctx context.Context, req *connect.Request[v1.ProjectAssignDiskDirsRequest]) (*connect.Response[v1.ProjectAssignDiskDirsResponse], error) {
-	return nil, connect.NewError(connect.CodeUnimplemented, fmt.Errorf("ProjectAssignDiskDirs is not implemented"))
+	if resp, err := findMatchingResponse[v1.ProjectAssignDiskDirsResponse](ctx, s, req); resp != nil || err != nil {
+		return resp, err
+	}
+	if err := s.model(ctx).AssignDiscDirs(req.Msg.Project, req.Msg.Dirs); err != nil {
+		return nil, s.explainMiss(ctx, req, err)
+	}
+	return connect.NewResponse(&v1.ProjectAssignDiskDirsResponse{}), nil
 }
 
 // ProjectGet searches for a matching request and returns the corresponding response
 func (s *StubService) ProjectGet(ctx context.Context, req *connect.Request[v1.ProjectGetRequest]) (*connect.Response[v1.ProjectGetResponse], error) {
-	found := data.FindProject(req.Msg.Project)
+	if resp, err := findMatchingResponse[v1.ProjectGetResponse](ctx, s, req); resp != nil || err != nil {
+		return resp, err
+	}
+	found := s.model(ctx).GetProject(req.Msg.Project)
 	if found == nil {
-		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("project not found: %s", req.Msg.Project))
+		return nil, s.explainMiss(ctx, req, connect.NewError(connect.CodeNotFound, fmt.Errorf("project not found: %s", req.Msg.Project)))
 	}
 	return connect.NewResponse(found), nil
 }
 
-// ProjectCategorizeFiles searches for a matching request and returns the corresponding response
+// ProjectCategorizeFiles updates the categories of files in a project
 func (s *StubService) ProjectCategorizeFiles(ctx context.Context, req *connect.Request[v1.ProjectCategorizeFilesRequest]) (*connect.Response[v1.ProjectCategorizeFilesResponse], error) {
-	return nil, connect.NewError(connect.CodeUnimplemented, fmt.Errorf("ProjectCategorizeFiles is not implemented"))
+	if resp, err := findMatchingResponse[v1.ProjectCategorizeFilesResponse](ctx, s, req); resp != nil || err != nil {
+		return resp, err
+	}
+	if err := s.model(ctx).CategorizeFiles(req.Msg.Project, req.Msg.Files); err != nil {
+		return nil, s.explainMiss(ctx, req, err)
+	}
+	return connect.NewResponse(&v1.ProjectCategorizeFilesResponse{}), nil
 }
 
 // MovieSearch searches for a matching request and returns the corresponding response
 func (s *StubService) MovieSearch(ctx context.Context, req *connect.Request[v1.MovieSearchRequest]) (*connect.Response[v1.MovieSearchResponse], error) {
+	if resp, err := findMatchingResponse[v1.MovieSearchResponse](ctx, s, req); resp != nil || err != nil {
+		return resp, err
+	}
 	resp := &v1.MovieSearchResponse{}
-	results := data.FindMetadata(req.Msg.PartialTitle)
-	resp.Results = slices.Collect(results)
+	resp.Results = s.model(ctx).SearchMetadata(req.Msg.PartialTitle)
 	return connect.NewResponse(resp), nil
 }
 
-// ProjectSetMetadata searches for a matching request and returns the corresponding response
+// ProjectSetMetadata records a movie from the MovieSearch catalog as a project's metadata
 func (s *StubService) ProjectSetMetadata(ctx context.Context, req *connect.Request[v1.ProjectSetMetadataRequest]) (*connect.Response[v1.ProjectSetMetadataResponse], error) {
-	return nil, connect.NewError(connect.CodeUnimplemented, fmt.Errorf("ProjectSetMetadata is not implemented"))
+	if resp, err := findMatchingResponse[v1.ProjectSetMetadataResponse](ctx, s, req); resp != nil || err != nil {
+		return resp, err
+	}
+	if err := s.model(ctx).SetMetadata(req.Msg.Project, req.Msg.Id); err != nil {
+		return nil, s.explainMiss(ctx, req, err)
+	}
+	return connect.NewResponse(&v1.ProjectSetMetadataResponse{}), nil
 }
 
-// ProjectFinish searches for a matching request and returns the corresponding response
+// ProjectFinish checks that a project is complete and moves it to the finished state
 func (s *StubService) ProjectFinish(ctx context.Context, req *connect.Request[v1.ProjectFinishRequest]) (*connect.Response[v1.ProjectFinishResponse], error) {
-	return nil, connect.NewError(connect.CodeUnimplemented, fmt.Errorf("ProjectFinish is not implemented"))
+	if resp, err := findMatchingResponse[v1.ProjectFinishResponse](ctx, s, req); resp != nil || err != nil {
+		return resp, err
+	}
+	if err := s.model(ctx).FinishProject(req.Msg.Project); err != nil {
+		return nil, s.explainMiss(ctx, req, err)
+	}
+	return connect.NewResponse(&v1.ProjectFinishResponse{}), nil
 }
 
-// ProjectAbandon searches for a matching request and returns the corresponding response
+// ProjectAbandon abandons a project and releases its discs back to the unclaimed list
 func (s *StubService) ProjectAbandon(ctx context.Context, req *connect.Request[v1.ProjectAbandonRequest]) (*connect.Response[v1.ProjectAbandonResponse], error) {
-	return nil, connect.NewError(connect.CodeUnimplemented, fmt.Errorf("ProjectAbandon is not implemented"))
+	if resp, err := findMatchingResponse[v1.ProjectAbandonResponse](ctx, s, req); resp != nil || err != nil {
+		return resp, err
+	}
+	if err := s.model(ctx).AbandonProject(req.Msg.Project); err != nil {
+		return nil, s.explainMiss(ctx, req, err)
+	}
+	return connect.NewResponse(&v1.ProjectAbandonResponse{}), nil
 }
 
 // NewStubService creates a new StubService with predefined request/response mappings
-func NewStubService() *StubService {
+// that serves the models in store
+func NewStubService(store *ModelStore) *StubService {
 	return &StubService{
+		store: store,
 		// Example mapping for HelloWorld
-		helloWorldMappings: []RequestResponseMapping[*v1.HelloWorldRequest, *v1.HelloWorldResponse]{
+		mappings: []*RequestResponseMapping{
 			{
-				Request:  &v1.HelloWorldRequest{Name: ""},
-				Response: &v1.HelloWorldResponse{Message: "Hello, empty!"},
+				Procedure: inv1connect.ServiceHelloWorldProcedure,
+				Request:   &v1.HelloWorldRequest{Name: ""},
+				Exact:     true, // an empty request would otherwise match any name
+				Response:  &v1.HelloWorldResponse{Message: "Hello, empty!"},
 			},
 			{
-				Request:  &v1.HelloWorldRequest{Name: "test"},
-				Response: &v1.HelloWorldResponse{Message: "Hello, test!"},
+				Procedure: inv1connect.ServiceHelloWorldProcedure,
+				Request:   &v1.HelloWorldRequest{Name: "test"},
+				Response:  &v1.HelloWorldResponse{Message: "Hello, test!"},
 			},
 			{
-				Request:  &v1.HelloWorldRequest{Name: "world"},
-				Response: &v1.HelloWorldResponse{Message: "Hello, world!"},
+				Procedure: inv1connect.ServiceHelloWorldProcedure,
+				Request:   &v1.HelloWorldRequest{Name: "world"},
+				Response:  &v1.HelloWorldResponse{Message: "Hello, world!"},
 			},
 		},
 	}
 }
 
+// newLogger returns a logger that writes records to w in format, "text" or
+// "json", if they are at least as severe as level, e.g. "info".
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var opts slog.HandlerOptions
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log level: %w", err)
+	}
+	opts.Level = l
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, &opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, &opts)), nil
+	}
+	return nil, fmt.Errorf("log format: unknown format %q, want text or json", format)
+}
+
 func main() {
-	stubService := NewStubService()
+	fixture := flag.String("fixture", os.Getenv("STUB_FIXTURE"), "JSON or YAML file to load the model from instead of the built-in data (env STUB_FIXTURE)")
+	fixturePoll := flag.Duration("fixture-poll", time.Second, "how often to check the fixture file for changes; 0 disables reloading")
+	admin := flag.Bool("admin", true, "serve the admin API under /admin/")
+	faultsFile := flag.String("faults", "", "JSON or YAML file of fault injection rules")
+	upstream := flag.String("upstream", "", "URL of a backend to forward every call to instead of serving the model")
+	hybrid := flag.Bool("hybrid", false, "with -upstream, only forward the calls that match a passthrough mapping")
+	record := flag.String("record", "", "JSON Lines file to append every call and its result to")
+	replayMappings := flag.String("replay-mappings", "", "JSON Lines recording to turn into mappings that answer its calls as they were recorded")
+	replayFile := flag.String("replay", "", "JSON Lines recording to answer calls from, matching them by procedure and request")
+	replayStrict := flag.Bool("replay-strict", true, "fail calls that match no recorded call instead of serving them from the mappings and model")
+	replayOrdered := flag.Bool("replay-ordered", false, "require calls to arrive in the order they were recorded")
+	journalSize := flag.Int("journal-size", 10000, "how many of the most recent RPCs to keep in the call journal; 0 disables it")
+	thumbs := flag.Bool("thumbs", true, "simulate thumbnail generation for newly assigned discs")
+	thumbsWait := flag.Duration("thumbs-wait", 2*time.Second, "how long a newly assigned disc waits before its thumbnails start")
+	thumbsWork := flag.Duration("thumbs-work", 5*time.Second, "how long a disc's thumbnails take to generate")
+	thumbsErrorRate := flag.Float64("thumbs-error-rate", 0, "probability that a disc's thumbnails fail")
+	thumbsSeed := flag.Uint64("thumbs-seed", 0, "seed for thumbnail failures; 0 picks a random seed")
+	logFormat := flag.String("log-format", "text", "log format: text or json")
+	logLevel := flag.String("log-level", "info", "minimum level to log: debug, info, warn or error")
+	logPayloads := flag.String("log-payloads", "full", "how to log request and response messages: full, summary (repeated fields as counts) or none")
+	logPayloadMax := flag.Int("log-payload-max", 0, "most bytes of each logged message before it is truncated; 0 means no limit")
+	logRedact := flag.String("log-redact", "", "comma-separated field paths whose values are redacted in logs, e.g. discs.discFiles")
+	flag.Parse()
+
+	logger, err := newLogger(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Route the log package, and so every other log line, through logger too.
+	slog.SetDefault(logger)
+	if *logPayloadMax < 0 {
+		log.Fatalf("Invalid -log-payload-max %d: must not be negative", *logPayloadMax)
+	}
+	payloads := PayloadOptions{MaxBytes: *logPayloadMax}
+	if payloads.Mode, err = ParsePayloadMode(*logPayloads); err != nil {
+		log.Fatalf("Invalid -log-payloads: %v", err)
+	}
+	if *logRedact != "" {
+		payloads.Redact = strings.Split(*logRedact, ",")
+		if err := payloads.CheckRedact(); err != nil {
+			log.Fatalf("Invalid -log-redact: %v", err)
+		}
+	}
+
+	if *journalSize < 0 {
+		log.Fatalf("Invalid -journal-size %d: must not be negative", *journalSize)
+	}
+
+	if *thumbsWait < 0 {
+		log.Fatalf("Invalid -thumbs-wait %v: must not be negative", *thumbsWait)
+	}
+	if *thumbsWork < 0 {
+		log.Fatalf("Invalid -thumbs-work %v: must not be negative", *thumbsWork)
+	}
+	if *thumbsErrorRate < 0 || *thumbsErrorRate > 1 {
+		log.Fatalf("Invalid -thumbs-error-rate %v: must be between 0 and 1", *thumbsErrorRate)
+	}
+
+	var thumbConfig *ThumbConfig
+	if *thumbs {
+		thumbConfig = &ThumbConfig{
+			WaitDelay: *thumbsWait,
+			WorkDelay: *thumbsWork,
+			ErrorRate: *thumbsErrorRate,
+			Seed:      *thumbsSeed,
+		}
+		if thumbConfig.Seed == 0 {
+			thumbConfig.Seed = rand.Uint64()
+		}
+		log.Printf("Simulating thumbnails with seed %d", thumbConfig.Seed)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store := NewModelStore(data.Clone(), thumbConfig)
+	defer store.Close()
+	if *fixture != "" {
+		m, err := LoadFixture(*fixture)
+		if err != nil {
+			log.Fatalf("Failed to load fixture: %v", err)
+		}
+		store.Replace("", m)
+		log.Printf("Loaded fixture %s", *fixture)
+
+		if *fixturePoll > 0 {
+			go newFixtureWatcher(*fixture, store).run(ctx, *fixturePoll)
+		}
+	}
+
+	journal := NewJournal(store.Clock(), *journalSize)
+
+	faults := &FaultInterceptor{Clock: store.Clock()}
+	if *faultsFile != "" {
+		rules, err := LoadFaults(*faultsFile)
+		if err != nil {
+			log.Fatalf("Failed to load faults: %v", err)
+		}
+		faults.SetRules(rules)
+		log.Printf("Loaded %d fault rules from %s", len(rules), *faultsFile)
+	}
+
+	stubService := NewStubService(store)
+	if *replayMappings != "" {
+		xs, err := LoadRecording(*replayMappings)
+		if err != nil {
+			log.Fatalf("Failed to load replay mappings: %v", err)
+		}
+		stubService.mappings = append(RecordingMappings(xs), stubService.mappings...)
+		log.Printf("Loaded %d recorded calls from %s", len(xs), *replayMappings)
+	}
+	for _, m := range stubService.mappings {
+		if err := m.check(); err != nil {
+			log.Fatalf("Invalid mapping: %v", err)
+		}
+	}
+	if *replayFile != "" {
+		xs, err := LoadRecording(*replayFile)
+		if err != nil {
+			log.Fatalf("Failed to load replay: %v", err)
+		}
+		stubService.replay = NewReplay(xs)
+		stubService.replay.Strict = *replayStrict
+		stubService.replay.Ordered = *replayOrdered
+		log.Printf("Replaying %d recorded calls from %s", len(xs), *replayFile)
+	}
+	var service inv1connect.ServiceHandler = stubService
+	var proxy *ProxyService
+	switch {
+	case *upstream != "" && *hybrid:
+		proxy = NewProxyService(inv1connect.NewServiceClient(http.DefaultClient, *upstream))
+		log.Printf("Forwarding passthrough calls to %s", *upstream)
+	case *upstream != "":
+		service = NewProxyService(inv1connect.NewServiceClient(http.DefaultClient, *upstream))
+		log.Printf("Forwarding calls to %s", *upstream)
+	case *hybrid:
+		log.Fatal("-hybrid needs -upstream")
+	}
 
 	// Create the logging interceptor
-	loggingInterceptor := &LoggingInterceptor{}
+	loggingInterceptor := &LoggingInterceptor{Logger: logger, Payloads: payloads, Journal: journal}
+
+	// Create the handler with the logging, fault, recording, passthrough and
+	// session interceptors.  Faults are injected inside the logging interceptor so
+	// that injected errors and dropped connections are logged and journaled
+	// like real ones, and outside the recorder so that they are not recorded
+	// as the service's behaviour.
+	interceptors := []connect.Interceptor{loggingInterceptor, faults}
+	if *record != "" {
+		f, err := os.OpenFile(*record, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("Failed to open recording: %v", err)
+		}
+		defer f.Close()
+		interceptors = append(interceptors, NewRecorder(f))
+		log.Printf("Recording calls to %s", *record)
+	}
+	if proxy != nil {
+		interceptors = append(interceptors, &PassthroughInterceptor{Proxy: proxy})
+	}
+	interceptors = append(interceptors, &SessionInterceptor{Store: store})
 
-	// Create the handler with the logging interceptor
 	path, handler := inv1connect.NewServiceHandler(
-		stubService,
-		connect.WithInterceptors(loggingInterceptor),
+		service,
+		connect.WithInterceptors(interceptors...),
 	)
 
 	mux := http.NewServeMux()
 	mux.Handle(path, handler)
+	if *admin {
+		mux.Handle("/admin/", NewAdminHandler(store, *fixture, faults, journal, stubService.replay))
+	}
 
 	// Support HTTP/2 without TLS for development
 	server := &http.Server{
@@ -205,6 +487,23 @@ func main() {
 		Handler: h2c.NewHandler(mux, &http2.Server{}),
 	}
 
+	// Stop accepting requests on SIGINT or SIGTERM, and let in-flight ones
+	// finish before the simulators are stopped.
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Shutdown: %v", err)
+		}
+	}()
+
 	fmt.Println("Starting video-in stub server on :8080")
-	log.Fatal(server.ListenAndServe())
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdown
+	log.Println("Server stopped")
 }
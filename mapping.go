@@ -0,0 +1,527 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// maxClosestMappings is how many near misses a NotFound error lists.
+const maxClosestMappings = 3
+
+// RequestResponseMapping is a canned response to calls of Procedure whose
+// request matches.  A matching mapping takes precedence over the model.
+//
+// By default a request matches if it agrees with every field set in Request,
+// recursively through message fields, and satisfies every one of Matchers.
+// Fields left unset in Request match anything.  If Exact is set, the request
+// must instead equal Request.
+//
+// A mapping answers with Response, or with each of Sequence in turn if it is
+// set; Mode says what happens once they run out.  String fields of responses
+// and error messages may be templates, see renderResponse, unless the mapping
+// is Literal.  A Passthrough
+// mapping instead has the call forwarded to the upstream backend; see
+// PassthroughInterceptor.
+type RequestResponseMapping struct {
+	Name      string // optional, to identify the mapping in errors
+	Procedure string // e.g. "/krelinga.video.in.v1.Service/ProjectGet"
+	Request   proto.Message
+	Exact     bool
+	Matchers  []FieldMatcher
+	Response  proto.Message
+	Sequence  []MappingReply
+	Mode      SequenceMode
+
+	Passthrough bool
+
+	// Literal mappings answer with their replies as they are, without
+	// rendering templates, as for replies recorded from real traffic.
+	Literal bool
+
+	// Priority orders mappings that match the same request: the highest
+	// wins, and ties go to the mapping listed first.
+	Priority int
+}
+
+// MappingReply is one reply in a mapping's sequence: Response, or an error if
+// Code is set.
+type MappingReply struct {
+	Response proto.Message
+	Code     connect.Code
+	Message  string
+}
+
+// SequenceMode says how a mapping answers once it has used up its replies.
+type SequenceMode string
+
+const (
+	SequenceStop  SequenceMode = "stop"  // repeat the last reply; the default
+	SequenceCycle SequenceMode = "cycle" // start over from the first reply
+	SequenceFail  SequenceMode = "fail"  // fail every further call
+)
+
+// replies returns the replies of m in order.
+func (m *RequestResponseMapping) replies() []MappingReply {
+	if len(m.Sequence) > 0 {
+		return m.Sequence
+	}
+	return []MappingReply{{Response: m.Response}}
+}
+
+// reply returns the reply to the seq'th call answered by m, counting from 1.
+func (m *RequestResponseMapping) reply(seq int) (MappingReply, error) {
+	replies := m.replies()
+	i := seq - 1
+	if i >= len(replies) {
+		switch m.Mode {
+		case SequenceCycle:
+			i %= len(replies)
+		case SequenceFail:
+			return MappingReply{}, connect.NewError(connect.CodeResourceExhausted,
+				fmt.Errorf("mapping %s: all %d replies used; reset with POST /admin/mappings/reset", m.describe(), len(replies)))
+		default:
+			i = len(replies) - 1
+		}
+	}
+	return replies[i], nil
+}
+
+// FieldMatcher is a predicate on a field of the request, named by a
+// dot-separated path of field names such as "project" or "files.disc".  A path
+// through a repeated field names every element, and the matcher is satisfied
+// if any of them is.  Exactly one of Regex, Prefix and Contains is used.
+type FieldMatcher struct {
+	Field    string
+	Regex    *regexp.Regexp // a string field matches Regex
+	Prefix   string         // a string field starts with Prefix
+	Contains string         // a repeated field has an element equal to Contains
+}
+
+func (f *FieldMatcher) String() string {
+	switch {
+	case f.Regex != nil:
+		return fmt.Sprintf("%s matches %q", f.Field, f.Regex)
+	case f.Contains != "":
+		return fmt.Sprintf("%s contains %q", f.Field, f.Contains)
+	default:
+		return fmt.Sprintf("%s has prefix %q", f.Field, f.Prefix)
+	}
+}
+
+// check reports a problem with f as a matcher for requests of type md.
+func (f *FieldMatcher) check(md protoreflect.MessageDescriptor) error {
+	leaf, repeated, err := fieldPath(md, f.Field)
+	if err != nil {
+		return err
+	}
+	switch {
+	case f.Contains != "":
+		if !repeated {
+			return fmt.Errorf("contains needs a repeated field, %s is not", f.Field)
+		}
+	case leaf.Kind() != protoreflect.StringKind:
+		return fmt.Errorf("regex and prefix need a string field, %s is %s", f.Field, leaf.Kind())
+	}
+	return nil
+}
+
+// match reports whether msg satisfies f.
+func (f *FieldMatcher) match(msg protoreflect.Message) bool {
+	values, err := fieldValues(msg, strings.Split(f.Field, "."))
+	if err != nil {
+		return false
+	}
+	for _, v := range values {
+		switch {
+		case f.Regex != nil:
+			if f.Regex.MatchString(v) {
+				return true
+			}
+		case f.Contains != "":
+			if v == f.Contains {
+				return true
+			}
+		default:
+			if strings.HasPrefix(v, f.Prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fieldPath resolves a dot-separated path of field names in md, returning the
+// last field and whether the path passes through a repeated field.
+func fieldPath(md protoreflect.MessageDescriptor, path string) (protoreflect.FieldDescriptor, bool, error) {
+	var fd protoreflect.FieldDescriptor
+	repeated := false
+	for name := range strings.SplitSeq(path, ".") {
+		if md == nil {
+			return nil, false, fmt.Errorf("field %s: %s is not a message", path, fd.Name())
+		}
+		fd = findField(md, name)
+		if fd == nil {
+			return nil, false, fmt.Errorf("field %s: %s has no field %s", path, md.FullName(), name)
+		}
+		if fd.IsMap() {
+			return nil, false, fmt.Errorf("field %s: maps are not supported", path)
+		}
+		repeated = repeated || fd.IsList()
+		md = fd.Message()
+	}
+	if md != nil {
+		return nil, false, fmt.Errorf("field %s: is a message, not a value", path)
+	}
+	return fd, repeated, nil
+}
+
+// findField looks up a field by its JSON or proto name.
+func findField(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := md.Fields().ByJSONName(name); fd != nil {
+		return fd
+	}
+	return md.Fields().ByTextName(name)
+}
+
+// fieldValues returns the values at path in msg, as strings, expanding
+// repeated fields.
+func fieldValues(msg protoreflect.Message, path []string) ([]string, error) {
+	fd := findField(msg.Descriptor(), path[0])
+	if fd == nil || fd.IsMap() {
+		return nil, fmt.Errorf("no field %s in %s", path[0], msg.Descriptor().FullName())
+	}
+	var elems []protoreflect.Value
+	if fd.IsList() {
+		list := msg.Get(fd).List()
+		for i := range list.Len() {
+			elems = append(elems, list.Get(i))
+		}
+	} else {
+		elems = append(elems, msg.Get(fd))
+	}
+	var out []string
+	for _, v := range elems {
+		if len(path) == 1 {
+			out = append(out, valueString(fd, v))
+			continue
+		}
+		if fd.Message() == nil {
+			return nil, fmt.Errorf("%s is not a message", fd.Name())
+		}
+		sub, err := fieldValues(v.Message(), path[1:])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sub...)
+	}
+	return out, nil
+}
+
+// valueString formats a scalar value of fd the way it is written in fixtures.
+func valueString(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+	case protoreflect.BytesKind:
+		return string(v.Bytes())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// mismatches returns the reasons that req, a request for m.Procedure, does
+// not match m.  It returns nothing if req matches.
+func (m *RequestResponseMapping) mismatches(req proto.Message) []string {
+	var reasons []string
+	if m.Exact {
+		if !proto.Equal(m.Request, req) {
+			reasons = append(reasons, "request differs")
+		}
+	} else if m.Request != nil {
+		reasons = partialMismatches(m.Request.ProtoReflect(), req.ProtoReflect(), "")
+	}
+	for _, f := range m.Matchers {
+		if !f.match(req.ProtoReflect()) {
+			reasons = append(reasons, "want "+f.String())
+		}
+	}
+	return reasons
+}
+
+// partialMismatches compares the fields set in want with the same fields of
+// got, descending into singular message fields.
+func partialMismatches(want, got protoreflect.Message, prefix string) []string {
+	var reasons []string
+	want.Range(func(fd protoreflect.FieldDescriptor, wv protoreflect.Value) bool {
+		path := prefix + fd.JSONName()
+		if fd.Message() != nil && !fd.IsList() && !fd.IsMap() {
+			// A message set in the pattern must be set in the request too,
+			// even if the pattern's message is empty.
+			if !got.Has(fd) {
+				reasons = append(reasons, path+" is unset")
+				return true
+			}
+			reasons = append(reasons, partialMismatches(wv.Message(), got.Get(fd).Message(), path+".")...)
+			return true
+		}
+		// Compare through single-field messages, so that lists, maps and
+		// scalars all get proto.Equal semantics.
+		w, g := want.Type().New(), got.Type().New()
+		w.Set(fd, wv)
+		if got.Has(fd) {
+			g.Set(fd, got.Get(fd))
+		}
+		if !proto.Equal(w.Interface(), g.Interface()) {
+			if fd.IsList() || fd.IsMap() {
+				reasons = append(reasons, path+" differs")
+			} else {
+				reasons = append(reasons, fmt.Sprintf("%s is %q, want %q", path, valueString(fd, got.Get(fd)), valueString(fd, wv)))
+			}
+		}
+		return true
+	})
+	return reasons
+}
+
+// sameKey reports whether m and o match the same requests, so that one
+// replaces the other when merged.
+func (m *RequestResponseMapping) sameKey(o *RequestResponseMapping) bool {
+	return m.Procedure == o.Procedure &&
+		m.Exact == o.Exact &&
+		proto.Equal(m.Request, o.Request) &&
+		slices.EqualFunc(m.Matchers, o.Matchers, func(a, b FieldMatcher) bool {
+			return a.String() == b.String()
+		})
+}
+
+// clone returns a deep copy of m.
+func (m *RequestResponseMapping) clone() *RequestResponseMapping {
+	c := *m
+	c.Request = proto.Clone(m.Request)
+	c.Response = proto.Clone(m.Response)
+	c.Matchers = slices.Clone(m.Matchers)
+	c.Sequence = nil
+	for _, r := range m.Sequence {
+		r.Response = proto.Clone(r.Response)
+		c.Sequence = append(c.Sequence, r)
+	}
+	return &c
+}
+
+// describe identifies m in error messages, by name if it has one and by what
+// it matches otherwise.
+func (m *RequestResponseMapping) describe() string {
+	if m.Name != "" {
+		return strconv.Quote(m.Name)
+	}
+	var parts []string
+	if m.Request != nil {
+		b, _ := protojson.Marshal(m.Request)
+		parts = append(parts, "request "+string(b))
+	}
+	for _, f := range m.Matchers {
+		parts = append(parts, f.String())
+	}
+	if m.Priority != 0 {
+		parts = append(parts, fmt.Sprintf("priority %d", m.Priority))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// findMapping returns the matching mapping with the highest priority among
+// candidates, all of which are for req's procedure, or nil if none match.
+func findMapping(candidates []*RequestResponseMapping, req proto.Message) *RequestResponseMapping {
+	var best *RequestResponseMapping
+	for _, m := range candidates {
+		if (best == nil || m.Priority > best.Priority) && len(m.mismatches(req)) == 0 {
+			best = m
+		}
+	}
+	return best
+}
+
+// mappingsFor returns the mappings that could answer a call of procedure: those
+// of the request's model, followed by those of s.
+func (s *StubService) mappingsFor(ctx context.Context, procedure string) []*RequestResponseMapping {
+	out := s.model(ctx).MappingsFor(procedure)
+	for _, m := range s.mappings {
+		if m.Procedure == procedure {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// findMatchingResponse returns the canned reply to req from the recording s
+// replays, if any, or else from the mapping that matches it, rendered for this
+// call: a response, or an error.  Returns nil and no error if neither answers
+// req, in which case the handler falls through to the model.
+func findMatchingResponse[Resp any](ctx context.Context, s *StubService, req connect.AnyRequest) (*connect.Response[Resp], error) {
+	if resp, err := findReplayedResponse[Resp](s, req); resp != nil || err != nil {
+		return resp, err
+	}
+	msg, ok := req.Any().(proto.Message)
+	if !ok {
+		return nil, nil
+	}
+	model := s.model(ctx)
+	m := findMapping(s.mappingsFor(ctx, req.Spec().Procedure), msg)
+	if m == nil {
+		return nil, nil
+	}
+	if m.Passthrough {
+		return nil, connect.NewError(connect.CodeUnimplemented,
+			fmt.Errorf("mapping %s passes calls through, but the stub is not forwarding to an upstream backend: %w", m.describe(), errPassthrough))
+	}
+	zero, ok := any(new(Resp)).(proto.Message)
+	if !ok {
+		return nil, nil
+	}
+	// Check every reply before counting the call, so that a mapping that
+	// cannot answer it is not moved along its sequence.
+	if err := m.checkResponses(zero.ProtoReflect().Descriptor().FullName()); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	seq := model.nextSeq(m)
+	reply, err := m.reply(seq)
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := m.render(reply, msg, seq, model)
+	if err != nil {
+		return nil, err
+	}
+	resp, ok := any(rendered).(*Resp)
+	if !ok {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("mapping %s: rendered a %T response", m.describe(), rendered))
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// check reports a problem with m: a procedure the service does not have, or
+// a request or response that is not of the procedure's type.  Mappings
+// decoded from fixtures are typed by their procedure, but those built in Go
+// are not.
+func (m *RequestResponseMapping) check() error {
+	method, err := findMethod(m.Procedure)
+	if err != nil {
+		return fmt.Errorf("mapping %s: %w", m.describe(), err)
+	}
+	if m.Request != nil && m.Request.ProtoReflect().Descriptor().FullName() != method.Input().FullName() {
+		return fmt.Errorf("mapping %s: request is a %s, want %s", m.describe(), responseTypeName(m.Request), method.Input().FullName())
+	}
+	return m.checkResponses(method.Output().FullName())
+}
+
+// checkResponses reports the first reply of m whose response is not a want.
+func (m *RequestResponseMapping) checkResponses(want protoreflect.FullName) error {
+	if m.Passthrough {
+		return nil
+	}
+	for i, reply := range m.replies() {
+		if got := responseTypeName(reply.Response); reply.Code == 0 && got != want {
+			return fmt.Errorf("mapping %s: reply %d is a %s response, want %s", m.describe(), i+1, got, want)
+		}
+	}
+	return nil
+}
+
+// render returns reply, the reply of m to the seq'th call it answered, with
+// its templates executed for req, or the error reply as an error.
+func (m *RequestResponseMapping) render(reply MappingReply, req proto.Message, seq int, model *Model) (proto.Message, error) {
+	if m.Literal {
+		if reply.Code != 0 {
+			return nil, connect.NewError(reply.Code, errors.New(reply.Message))
+		}
+		return proto.Clone(reply.Response), nil
+	}
+	data, err := newTemplateData(req, seq, model.Now())
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("mapping %s: %w", m.describe(), err))
+	}
+	if reply.Code != 0 {
+		text, err := renderString("message", reply.Message, data)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("mapping %s: %w", m.describe(), err))
+		}
+		return nil, connect.NewError(reply.Code, errors.New(text))
+	}
+	rendered, err := renderResponse(reply.Response, data)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("mapping %s: %w", m.describe(), err))
+	}
+	return rendered, nil
+}
+
+// explainMiss adds the mappings for req's procedure that came closest to
+// matching it to err, if err is a NotFound error.  This shows why a call
+// fell through to the model when a test expected a canned response.
+func (s *StubService) explainMiss(ctx context.Context, req connect.AnyRequest, err error) error {
+	msg, ok := req.Any().(proto.Message)
+	if !ok || connect.CodeOf(err) != connect.CodeNotFound {
+		return err
+	}
+	type miss struct {
+		m       *RequestResponseMapping
+		reasons []string
+	}
+	var misses []miss
+	for _, m := range s.mappingsFor(ctx, req.Spec().Procedure) {
+		misses = append(misses, miss{m, m.mismatches(msg)})
+	}
+	if len(misses) == 0 {
+		return err
+	}
+	slices.SortStableFunc(misses, func(a, b miss) int {
+		return cmp.Compare(len(a.reasons), len(b.reasons))
+	})
+	var closest []string
+	for _, miss := range misses[:min(len(misses), maxClosestMappings)] {
+		closest = append(closest, fmt.Sprintf("{%s: %s}", miss.m.describe(), strings.Join(miss.reasons, ", ")))
+	}
+	text := err.Error()
+	if cerr := new(connect.Error); errors.As(err, &cerr) {
+		text = cerr.Message()
+	}
+	return connect.NewError(connect.CodeNotFound, fmt.Errorf("%s; closest mappings: %s", text, strings.Join(closest, "; ")))
+}
+
+// findMethod returns the method named by a procedure such as
+// "/krelinga.video.in.v1.Service/ProjectGet".
+func findMethod(procedure string) (protoreflect.MethodDescriptor, error) {
+	name, ok := strings.CutPrefix(procedure, "/")
+	if ok {
+		name = strings.Replace(name, "/", ".", 1)
+	}
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil || !ok {
+		return nil, fmt.Errorf("unknown procedure %s", procedure)
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("unknown procedure %s", procedure)
+	}
+	return md, nil
+}
+
+// newMessage returns a new, empty message of the type described by md.
+func newMessage(md protoreflect.MessageDescriptor) (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName())
+	if err != nil {
+		return nil, err
+	}
+	return mt.New().Interface(), nil
+}
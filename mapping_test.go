@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"buf.build/gen/go/krelinga/proto/connectrpc/go/krelinga/video/in/v1/inv1connect"
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMappings(t *testing.T) {
+	m, err := ParseFixture([]byte(`
+projects:
+  - project: Real
+mappings:
+  - procedure: /krelinga.video.in.v1.Service/ProjectGet
+    request: {project: Canned}
+    response:
+      project: Canned
+      discs: [{disc: Canned Disc, thumbState: done}]
+  - procedure: /krelinga.video.in.v1.Service/ProjectList
+    response: {projects: [From Mapping]}
+  - procedure: /krelinga.video.in.v1.Service/HelloWorld
+    request: {name: test}
+    response: {message: Overridden}
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store := NewModelStore(m, nil)
+	_, handler := inv1connect.NewServiceHandler(
+		NewStubService(store),
+		connect.WithInterceptors(&SessionInterceptor{Store: store}),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	ctx := context.Background()
+
+	// A matching mapping takes precedence over the model...
+	get, err := client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: "Canned"}))
+	if err != nil {
+		t.Fatalf("ProjectGet call failed: %v", err)
+	}
+	if len(get.Msg.Discs) != 1 || get.Msg.Discs[0].Disc != "Canned Disc" {
+		t.Errorf("Expected the canned project, got %v", get.Msg)
+	}
+	list, err := client.ProjectList(ctx, connect.NewRequest(&v1.ProjectListRequest{}))
+	if err != nil {
+		t.Fatalf("ProjectList call failed: %v", err)
+	}
+	if got, want := list.Msg.Projects, []string{"From Mapping"}; !slices.Equal(got, want) {
+		t.Errorf("Expected projects %v, got %v", want, got)
+	}
+
+	// ...and anything else falls through to it.
+	get, err = client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: "Real"}))
+	if err != nil {
+		t.Fatalf("ProjectGet call failed: %v", err)
+	}
+	if get.Msg.Project != "Real" {
+		t.Errorf("Expected project Real from the model, got %v", get.Msg)
+	}
+
+	// Model mappings are consulted before the built-in ones.
+	hello, err := client.HelloWorld(ctx, connect.NewRequest(&v1.HelloWorldRequest{Name: "test"}))
+	if err != nil {
+		t.Fatalf("HelloWorld call failed: %v", err)
+	}
+	if hello.Msg.Message != "Overridden" {
+		t.Errorf("Expected the fixture's HelloWorld mapping, got %q", hello.Msg.Message)
+	}
+	hello, err = client.HelloWorld(ctx, connect.NewRequest(&v1.HelloWorldRequest{Name: "world"}))
+	if err != nil {
+		t.Fatalf("HelloWorld call failed: %v", err)
+	}
+	if hello.Msg.Message != "Hello, world!" {
+		t.Errorf("Expected the built-in HelloWorld mapping, got %q", hello.Msg.Message)
+	}
+
+	// Mappings survive a round trip through the fixture format.
+	b, err := m.MarshalFixture()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	back, err := ParseFixture(b)
+	if err != nil {
+		t.Fatalf("Failed to parse marshaled fixture: %v", err)
+	}
+	if len(back.Mappings) != len(m.Mappings) {
+		t.Fatalf("Expected %d mappings, got %d", len(m.Mappings), len(back.Mappings))
+	}
+	for i, mp := range back.Mappings {
+		if want := m.Mappings[i]; mp.Procedure != want.Procedure || !proto.Equal(mp.Request, want.Request) || !proto.Equal(mp.Response, want.Response) {
+			t.Errorf("Mapping %d: expected %v, got %v", i, want, mp)
+		}
+	}
+}
+
+func TestMappingMatching(t *testing.T) {
+	m, err := ParseFixture([]byte(`
+mappings:
+  - procedure: /krelinga.video.in.v1.Service/ProjectAssignDiskDirs
+    request: {project: Partial}
+    response: {}
+  - procedure: /krelinga.video.in.v1.Service/ProjectAssignDiskDirs
+    request: {project: Exact}
+    exact: true
+    response: {}
+  - procedure: /krelinga.video.in.v1.Service/ProjectAssignDiskDirs
+    match:
+      - {field: project, regex: "^Regex [0-9]+$"}
+    response: {}
+  - procedure: /krelinga.video.in.v1.Service/ProjectAssignDiskDirs
+    match:
+      - {field: project, prefix: "Prefix "}
+      - {field: dirs, contains: Wanted Dir}
+    response: {}
+  - procedure: /krelinga.video.in.v1.Service/ProjectCategorizeFiles
+    match:
+      - {field: files.category, contains: trash}
+    response: {}
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  proto.Message
+		want int // index of the matching mapping, or -1
+	}{
+		{name: "partial ignores unset fields", req: &v1.ProjectAssignDiskDirsRequest{Project: "Partial", Dirs: []string{"Any"}}, want: 0},
+		{name: "partial compares set fields", req: &v1.ProjectAssignDiskDirsRequest{Project: "Other"}, want: -1},
+		{name: "exact", req: &v1.ProjectAssignDiskDirsRequest{Project: "Exact"}, want: 1},
+		{name: "exact rejects extra fields", req: &v1.ProjectAssignDiskDirsRequest{Project: "Exact", Dirs: []string{"Any"}}, want: -1},
+		{name: "regex", req: &v1.ProjectAssignDiskDirsRequest{Project: "Regex 42"}, want: 2},
+		{name: "regex mismatch", req: &v1.ProjectAssignDiskDirsRequest{Project: "Regex X"}, want: -1},
+		{name: "prefix and contains", req: &v1.ProjectAssignDiskDirsRequest{Project: "Prefix A", Dirs: []string{"Other", "Wanted Dir"}}, want: 3},
+		{name: "prefix without contains", req: &v1.ProjectAssignDiskDirsRequest{Project: "Prefix A", Dirs: []string{"Other"}}, want: -1},
+		{name: "contains through repeated message", req: &v1.ProjectCategorizeFilesRequest{Files: []*v1.FileCategory{{Category: "extra"}, {Category: "trash"}}}, want: 4},
+		{name: "contains through repeated message mismatch", req: &v1.ProjectCategorizeFilesRequest{Files: []*v1.FileCategory{{Category: "extra"}}}, want: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procedure := inv1connect.ServiceProjectAssignDiskDirsProcedure
+			if _, ok := tt.req.(*v1.ProjectCategorizeFilesRequest); ok {
+				procedure = inv1connect.ServiceProjectCategorizeFilesProcedure
+			}
+			got := findMapping(m.MappingsFor(procedure), tt.req)
+			switch {
+			case tt.want < 0 && got != nil:
+				t.Errorf("Expected no match, got %s", got.describe())
+			case tt.want >= 0 && got != m.Mappings[tt.want]:
+				t.Errorf("Expected mapping %d to match, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPartialMismatches(t *testing.T) {
+	// No request has a nested message, so use a response to check presence.
+	want := &v1.ProjectGetResponse{SearchResult: &v1.MovieSearchResult{}}
+	for _, tt := range []struct {
+		name string
+		got  *v1.ProjectGetResponse
+		want []string
+	}{
+		{name: "unset", got: &v1.ProjectGetResponse{}, want: []string{"searchResult is unset"}},
+		{name: "empty", got: &v1.ProjectGetResponse{SearchResult: &v1.MovieSearchResult{}}},
+		{name: "set", got: &v1.ProjectGetResponse{SearchResult: &v1.MovieSearchResult{Title: "Any"}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := partialMismatches(want.ProtoReflect(), tt.got.ProtoReflect(), "")
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected mismatches %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMappingPriority(t *testing.T) {
+	m, err := ParseFixture([]byte(`
+mappings:
+  - procedure: /krelinga.video.in.v1.Service/ProjectList
+    response: {projects: [First]}
+  - procedure: /krelinga.video.in.v1.Service/ProjectList
+    response: {projects: [Tied]}
+  - procedure: /krelinga.video.in.v1.Service/ProjectList
+    priority: 5
+    response: {projects: [Highest]}
+  - procedure: /krelinga.video.in.v1.Service/ProjectList
+    priority: -1
+    response: {projects: [Lowest]}
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got := findMapping(m.MappingsFor(inv1connect.ServiceProjectListProcedure), &v1.ProjectListRequest{})
+	if got != m.Mappings[2] {
+		t.Errorf("Expected the highest priority mapping, got %s", got.describe())
+	}
+
+	m.Mappings = m.Mappings[:2]
+	got = findMapping(m.MappingsFor(inv1connect.ServiceProjectListProcedure), &v1.ProjectListRequest{})
+	if got != m.Mappings[0] {
+		t.Errorf("Expected the first of two tied mappings, got %s", got.describe())
+	}
+}
+
+func TestMappingNotFound(t *testing.T) {
+	m, err := ParseFixture([]byte(`
+mappings:
+  - procedure: /krelinga.video.in.v1.Service/ProjectGet
+    request: {project: Canned One}
+    response: {project: Canned One}
+  - procedure: /krelinga.video.in.v1.Service/ProjectGet
+    match:
+      - {field: project, prefix: "Test "}
+    response: {project: Test}
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store := NewModelStore(m, nil)
+	_, handler := inv1connect.NewServiceHandler(
+		NewStubService(store),
+		connect.WithInterceptors(&SessionInterceptor{Store: store}),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	_, err = client.ProjectGet(context.Background(), connect.NewRequest(&v1.ProjectGetRequest{Project: "Canned Two"}))
+	if connect.CodeOf(err) != connect.CodeNotFound {
+		t.Fatalf("Expected NotFound, got: %v", err)
+	}
+	for _, want := range []string{
+		"project not found: Canned Two",
+		`project is "Canned Two", want "Canned One"`,
+		`want project has prefix "Test "`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to contain %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestMappingTemplates(t *testing.T) {
+	m, err := ParseFixture([]byte(`
+mappings:
+  - procedure: /krelinga.video.in.v1.Service/HelloWorld
+    request: {name: broken}
+    exact: true
+    name: broken greeting
+    response: {message: "{{.Request.nmae}}"}
+  - procedure: /krelinga.video.in.v1.Service/HelloWorld
+    priority: -1
+    response: {message: "Hello, {{.Request.name}}! You are visitor {{.Seq}}."}
+  - procedure: /krelinga.video.in.v1.Service/MovieSearch
+    response:
+      results:
+        - title: "{{.Request.partialTitle}} II"
+          releaseDate: '{{.Now.Format "2006-01-02"}}'
+          genres: ["{{.Seq}}"]
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store := NewModelStore(m, nil)
+	store.Clock().Freeze()
+	_, handler := inv1connect.NewServiceHandler(
+		NewStubService(store),
+		connect.WithInterceptors(&SessionInterceptor{Store: store}),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	ctx := context.Background()
+	hello := func(name string) (string, error) {
+		resp, err := client.HelloWorld(ctx, connect.NewRequest(&v1.HelloWorldRequest{Name: name}))
+		if err != nil {
+			return "", err
+		}
+		return resp.Msg.Message, nil
+	}
+
+	for i, want := range []string{"Hello, Ada! You are visitor 1.", "Hello, Ada! You are visitor 2."} {
+		got, err := hello("Ada")
+		if err != nil {
+			t.Fatalf("HelloWorld call %d failed: %v", i+1, err)
+		}
+		if got != want {
+			t.Errorf("Call %d: expected %q, got %q", i+1, want, got)
+		}
+	}
+
+	// The built-in mappings still answer the names they know.
+	if got, err := hello("world"); err != nil || got != "Hello, world!" {
+		t.Errorf("Expected the built-in greeting, got %q, %v", got, err)
+	}
+
+	search, err := client.MovieSearch(ctx, connect.NewRequest(&v1.MovieSearchRequest{PartialTitle: "Movie"}))
+	if err != nil {
+		t.Fatalf("MovieSearch call failed: %v", err)
+	}
+	r := search.Msg.Results[0]
+	if want := store.Clock().Now().Format("2006-01-02"); r.Title != "Movie II" || r.ReleaseDate != want || !slices.Equal(r.Genres, []string{"1"}) {
+		t.Errorf("Expected Movie II released %s with genres [1], got %v", want, r)
+	}
+
+	_, err = hello("broken")
+	if connect.CodeOf(err) != connect.CodeInternal {
+		t.Fatalf("Expected Internal for a template that fails, got: %v", err)
+	}
+	for _, want := range []string{`"broken greeting"`, "response.message", `"nmae"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to contain %s, got: %v", want, err)
+		}
+	}
+}
+
+func TestMappingSequences(t *testing.T) {
+	m, err := ParseFixture([]byte(`
+mappings:
+  - procedure: /krelinga.video.in.v1.Service/ProjectGet
+    request: {project: Polled}
+    sequence:
+      - response: {project: Polled, discs: [{disc: D, thumbState: waiting}]}
+      - response: {project: Polled, discs: [{disc: D, thumbState: working}]}
+      - response: {project: Polled, discs: [{disc: D, thumbState: done}]}
+  - procedure: /krelinga.video.in.v1.Service/HelloWorld
+    request: {name: cycle}
+    mode: cycle
+    sequence:
+      - response: {message: tick}
+      - response: {message: tock}
+  - procedure: /krelinga.video.in.v1.Service/HelloWorld
+    request: {name: flaky}
+    mode: fail
+    sequence:
+      - error: {code: unavailable, message: "try again after call {{.Seq}}"}
+      - response: {message: ok}
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store := NewModelStore(m, nil)
+	_, handler := inv1connect.NewServiceHandler(
+		NewStubService(store),
+		connect.WithInterceptors(&SessionInterceptor{Store: store}),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	admin := httptest.NewServer(NewAdminHandler(store, "", &FaultInterceptor{}, NewJournal(store.Clock(), 100), nil))
+	defer admin.Close()
+
+	client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	ctx := context.Background()
+	state := func(session string) string {
+		t.Helper()
+		req := connect.NewRequest(&v1.ProjectGetRequest{Project: "Polled"})
+		req.Header().Set(SessionHeader, session)
+		resp, err := client.ProjectGet(ctx, req)
+		if err != nil {
+			t.Fatalf("ProjectGet call failed: %v", err)
+		}
+		return resp.Msg.Discs[0].ThumbState
+	}
+	hello := func(name string) (string, error) {
+		resp, err := client.HelloWorld(ctx, connect.NewRequest(&v1.HelloWorldRequest{Name: name}))
+		if err != nil {
+			return "", err
+		}
+		return resp.Msg.Message, nil
+	}
+
+	// By default the last reply repeats, and each session counts its own calls.
+	for i, want := range []string{"waiting", "working", "done", "done"} {
+		if got := state("a"); got != want {
+			t.Errorf("Session a call %d: expected %s, got %s", i+1, want, got)
+		}
+	}
+	if got := state("b"); got != "waiting" {
+		t.Errorf("Expected session b to start at waiting, got %s", got)
+	}
+
+	// Resetting a session's mappings starts its sequences over.
+	req, _ := http.NewRequest(http.MethodPost, admin.URL+"/admin/mappings/reset", nil)
+	req.Header.Set(SessionHeader, "a")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", resp.StatusCode)
+	}
+	if got := state("a"); got != "waiting" {
+		t.Errorf("Expected session a to start over at waiting, got %s", got)
+	}
+	if got := state("b"); got != "working" {
+		t.Errorf("Expected session b to carry on at working, got %s", got)
+	}
+
+	for i, want := range []string{"tick", "tock", "tick"} {
+		if got, err := hello("cycle"); err != nil || got != want {
+			t.Errorf("Cycle call %d: expected %q, got %q, %v", i+1, want, got, err)
+		}
+	}
+
+	_, err = hello("flaky")
+	if connect.CodeOf(err) != connect.CodeUnavailable || !strings.Contains(err.Error(), "try again after call 1") {
+		t.Errorf("Expected the scripted Unavailable, got: %v", err)
+	}
+	if got, err := hello("flaky"); err != nil || got != "ok" {
+		t.Errorf("Expected ok, got %q, %v", got, err)
+	}
+	if _, err = hello("flaky"); connect.CodeOf(err) != connect.CodeResourceExhausted {
+		t.Errorf("Expected ResourceExhausted once the sequence ran out, got: %v", err)
+	}
+
+	// Sequences survive a round trip through the fixture format.
+	b, err := m.MarshalFixture()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	back, err := ParseFixture(b)
+	if err != nil {
+		t.Fatalf("Failed to parse marshaled fixture: %v", err)
+	}
+	for i, mp := range back.Mappings {
+		want := m.Mappings[i]
+		if mp.Mode != want.Mode || len(mp.Sequence) != len(want.Sequence) {
+			t.Fatalf("Mapping %d: expected %v, got %v", i, want, mp)
+		}
+		for j, r := range mp.Sequence {
+			if w := want.Sequence[j]; r.Code != w.Code || r.Message != w.Message || !proto.Equal(r.Response, w.Response) {
+				t.Errorf("Mapping %d reply %d: expected %v, got %v", i, j, w, r)
+			}
+		}
+	}
+}
+
+func TestMappingResponseType(t *testing.T) {
+	for _, m := range NewStubService(NewModelStore(data.Clone(), nil)).mappings {
+		if err := m.check(); err != nil {
+			t.Errorf("Unexpected error for built-in mapping: %v", err)
+		}
+	}
+
+	wrong := &RequestResponseMapping{
+		Name:      "wrong",
+		Procedure: inv1connect.ServiceProjectGetProcedure,
+		Request:   &v1.ProjectGetRequest{Project: "Project 1"},
+		Sequence: []MappingReply{
+			{Response: &v1.ProjectGetResponse{Project: "Project 1"}},
+			{Response: &v1.HelloWorldResponse{Message: "not a project"}},
+		},
+	}
+	if err := wrong.check(); err == nil || !strings.Contains(err.Error(), "reply 2 is a krelinga.video.in.v1.HelloWorldResponse") {
+		t.Errorf("Expected an error naming the wrong reply, got: %v", err)
+	}
+
+	// A mapping that slips past the check fails the call rather than falling
+	// through to the model, and isn't moved along its sequence.
+	store := NewModelStore(data.Clone(), nil)
+	s := NewStubService(store)
+	s.mappings = append(s.mappings, wrong)
+	_, handler := inv1connect.NewServiceHandler(s, connect.WithInterceptors(&SessionInterceptor{Store: store}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	for range 2 {
+		_, err := client.ProjectGet(context.Background(), connect.NewRequest(&v1.ProjectGetRequest{Project: "Project 1"}))
+		if connect.CodeOf(err) != connect.CodeInternal || !strings.Contains(err.Error(), `mapping "wrong"`) {
+			t.Errorf("Expected an Internal error naming the mapping, got: %v", err)
+		}
+	}
+	if seq := store.Base().nextSeq(wrong); seq != 1 {
+		t.Errorf("Expected the failed calls not to be counted, got %d counted", seq-1)
+	}
+}
@@ -1,20 +1,130 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"iter"
+	"maps"
 	"slices"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"connectrpc.com/connect"
 	"github.com/krelinga/go-iters"
+	"google.golang.org/protobuf/proto"
 )
 
+// Thumbnail states reported in ProjectDisc.ThumbState.
+const (
+	ThumbStateWaiting = "waiting"
+	ThumbStateWorking = "working"
+	ThumbStateError   = "error"
+	ThumbStateDone    = "done"
+)
+
+// File categories accepted in DiscFile.Category.  CategoryUnset clears an
+// earlier categorization.
+const (
+	CategoryUnset     = ""
+	CategoryMainTitle = "main_title"
+	CategoryExtra     = "extra"
+	CategoryTrash     = "trash"
+)
+
+var validCategories = []string{CategoryUnset, CategoryMainTitle, CategoryExtra, CategoryTrash}
+
+// ProjectState is the lifecycle state of a project.  Projects start out active
+// and may be finished or abandoned; both of those states are final.
+type ProjectState string
+
+const (
+	ProjectActive    ProjectState = "active"
+	ProjectFinished  ProjectState = "finished"
+	ProjectAbandoned ProjectState = "abandoned"
+)
+
+// Model holds the state served by StubService.  The exported fields may only
+// be accessed directly while holding mu; handlers should go through the
+// methods below, which take the lock and return copies.
 type Model struct {
+	mu sync.RWMutex
+
 	Projects  []*v1.ProjectGetResponse
 	Unclaimed []string
 	Metadata  []*v1.MovieSearchResult
+
+	// Closed records the final state of projects that have left Projects,
+	// keyed by project name.
+	Closed map[string]ProjectState
+
+	// Mappings are canned responses that take precedence over the rest of the
+	// model, in order.
+	Mappings []*RequestResponseMapping
+
+	// thumbJobs are the assigned discs whose thumbnails are still being
+	// simulated, in assignment order.  They only advance while thumbs is
+	// running; see StartThumbs.
+	thumbJobs []thumbJob
+	thumbs    *thumbSimulator
+
+	// clock tells the time for thumbnail jobs and response templates.  Nil
+	// means real time.
+	clock Clock
+
+	// seqs counts the calls each mapping has answered.  It is keyed by
+	// mapping rather than stored in it so that the mappings built into
+	// StubService are counted separately for every model.
+	seqs map[*RequestResponseMapping]int
+}
+
+// SetClock makes m tell the time with c instead of real time.
+func (m *Model) SetClock(c Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = c
+}
+
+// Now returns the current time according to m's clock.
+func (m *Model) Now() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.now()
 }
 
+// nextSeq counts a call answered by mp, and returns how many it has answered,
+// including this one.
+func (m *Model) nextSeq(mp *RequestResponseMapping) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.seqs == nil {
+		m.seqs = make(map[*RequestResponseMapping]int)
+	}
+	m.seqs[mp]++
+	return m.seqs[mp]
+}
+
+// ResetSeqs forgets how many calls each mapping has answered, so that
+// sequences start over from their first reply.
+func (m *Model) ResetSeqs() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seqs = nil
+}
+
+// now returns the current time according to m's clock.  Callers must hold
+// m.mu.
+func (m *Model) now() time.Time {
+	if m.clock == nil {
+		return time.Now()
+	}
+	return m.clock.Now()
+}
+
+// FindProject returns the named project, or nil if it does not exist.
+// Callers must hold m.mu.
 func (m *Model) FindProject(name string) *v1.ProjectGetResponse {
 	for _, p := range m.Projects {
 		if p.Project == name {
@@ -24,6 +134,81 @@ func (m *Model) FindProject(name string) *v1.ProjectGetResponse {
 	return nil
 }
 
+// activeProject returns the named project if it is active, or a NotFound or
+// FailedPrecondition error explaining why it is not.  Callers must hold m.mu.
+func (m *Model) activeProject(name string) (*v1.ProjectGetResponse, error) {
+	if p := m.FindProject(name); p != nil {
+		return p, nil
+	}
+	if state, ok := m.Closed[name]; ok {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("project %s is %s", name, state))
+	}
+	return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("project not found: %s", name))
+}
+
+// closeProject removes the active project p from Projects and records its
+// final state.  Callers must hold m.mu.
+func (m *Model) closeProject(p *v1.ProjectGetResponse, state ProjectState) {
+	m.Projects = slices.DeleteFunc(m.Projects, func(q *v1.ProjectGetResponse) bool { return q == p })
+	m.dropThumbJobs(p.Project)
+	if m.Closed == nil {
+		m.Closed = make(map[string]ProjectState)
+	}
+	m.Closed[p.Project] = state
+}
+
+// dropThumbJobs stops simulating thumbnails for the discs of the named
+// project.  Callers must hold m.mu.
+func (m *Model) dropThumbJobs(project string) {
+	m.thumbJobs = slices.DeleteFunc(m.thumbJobs, func(j thumbJob) bool { return j.project == project })
+}
+
+// FindDiscOwner returns the project that has claimed dir, or nil if no
+// project has.  Callers must hold m.mu.
+func (m *Model) FindDiscOwner(dir string) *v1.ProjectGetResponse {
+	for _, p := range m.Projects {
+		for _, d := range p.Discs {
+			if d.Disc == dir {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// findDisc returns the named disc of p, or nil if it does not exist.
+func findDisc(p *v1.ProjectGetResponse, disc string) *v1.ProjectDisc {
+	for _, d := range p.Discs {
+		if d.Disc == disc {
+			return d
+		}
+	}
+	return nil
+}
+
+// findDiscFile returns the named file of d, or nil if it does not exist.
+func findDiscFile(d *v1.ProjectDisc, file string) *v1.DiscFile {
+	for _, f := range d.DiscFiles {
+		if f.File == file {
+			return f
+		}
+	}
+	return nil
+}
+
+// FindMetadataByID returns the catalog entry with the given id, or nil if it
+// does not exist.  Callers must hold m.mu.
+func (m *Model) FindMetadataByID(id string) *v1.MovieSearchResult {
+	for _, md := range m.Metadata {
+		if md.Id == id {
+			return md
+		}
+	}
+	return nil
+}
+
+// FindMetadata returns the catalog entries whose title contains name.
+// Callers must hold m.mu.
 func (m *Model) FindMetadata(name string) iter.Seq[*v1.MovieSearchResult] {
 	name = strings.ToLower(name)
 	return iters.Filter(slices.Values(m.Metadata), func(item *v1.MovieSearchResult) bool {
@@ -33,6 +218,352 @@ func (m *Model) FindMetadata(name string) iter.Seq[*v1.MovieSearchResult] {
 	})
 }
 
+// Clone returns a deep copy of m.
+func (m *Model) Clone() *Model {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c := &Model{
+		Unclaimed: slices.Clone(m.Unclaimed),
+		Closed:    maps.Clone(m.Closed),
+		thumbJobs: slices.Clone(m.thumbJobs),
+		clock:     m.clock,
+	}
+	for _, p := range m.Projects {
+		c.Projects = append(c.Projects, proto.Clone(p).(*v1.ProjectGetResponse))
+	}
+	for _, md := range m.Metadata {
+		c.Metadata = append(c.Metadata, proto.Clone(md).(*v1.MovieSearchResult))
+	}
+	for _, mp := range m.Mappings {
+		c.Mappings = append(c.Mappings, mp.clone())
+	}
+	return c
+}
+
+// Merge copies everything in patch into m: projects replace those with the
+// same name, metadata replaces entries with the same id, mappings replace those
+// that match the same requests, unclaimed dirs are added if missing, and
+// closed states are recorded.
+func (m *Model) Merge(patch *Model) {
+	patch = patch.Clone()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range patch.Projects {
+		delete(m.Closed, p.Project)
+		if i := slices.IndexFunc(m.Projects, func(q *v1.ProjectGetResponse) bool { return q.Project == p.Project }); i >= 0 {
+			m.Projects[i] = p
+			m.dropThumbJobs(p.Project)
+		} else {
+			m.Projects = append(m.Projects, p)
+		}
+	}
+	for _, dir := range patch.Unclaimed {
+		if !slices.Contains(m.Unclaimed, dir) {
+			m.Unclaimed = append(m.Unclaimed, dir)
+		}
+	}
+	for _, md := range patch.Metadata {
+		if i := slices.IndexFunc(m.Metadata, func(q *v1.MovieSearchResult) bool { return q.Id == md.Id }); i >= 0 {
+			m.Metadata[i] = md
+		} else {
+			m.Metadata = append(m.Metadata, md)
+		}
+	}
+	for name, state := range patch.Closed {
+		if m.Closed == nil {
+			m.Closed = make(map[string]ProjectState)
+		}
+		m.Closed[name] = state
+	}
+	for _, mp := range patch.Mappings {
+		if i := slices.IndexFunc(m.Mappings, func(q *RequestResponseMapping) bool { return q.sameKey(mp) }); i >= 0 {
+			delete(m.seqs, m.Mappings[i])
+			m.Mappings[i] = mp
+		} else {
+			m.Mappings = append(m.Mappings, mp)
+		}
+	}
+}
+
+// RemoveProject deletes the named project outright, without recording a
+// final state.  Reports whether the project existed.
+func (m *Model) RemoveProject(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := len(m.Projects)
+	m.Projects = slices.DeleteFunc(m.Projects, func(p *v1.ProjectGetResponse) bool { return p.Project == name })
+	m.dropThumbJobs(name)
+	return len(m.Projects) != n
+}
+
+// RemoveUnclaimed deletes dir from Unclaimed.  Reports whether it was there.
+func (m *Model) RemoveUnclaimed(dir string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := len(m.Unclaimed)
+	m.Unclaimed = slices.DeleteFunc(m.Unclaimed, func(d string) bool { return d == dir })
+	return len(m.Unclaimed) != n
+}
+
+// RemoveMetadata deletes the catalog entry with the given id.  Reports whether
+// it existed.
+func (m *Model) RemoveMetadata(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := len(m.Metadata)
+	m.Metadata = slices.DeleteFunc(m.Metadata, func(md *v1.MovieSearchResult) bool { return md.Id == id })
+	return len(m.Metadata) != n
+}
+
+// MappingsFor returns the mappings for procedure, in order.  The mappings
+// themselves are never modified once in the model, so they are not copied.
+func (m *Model) MappingsFor(procedure string) []*RequestResponseMapping {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []*RequestResponseMapping
+	for _, mp := range m.Mappings {
+		if mp.Procedure == procedure {
+			out = append(out, mp)
+		}
+	}
+	return out
+}
+
+// ProjectNames returns the names of all projects, in creation order.
+func (m *Model) ProjectNames() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.Projects))
+	for _, p := range m.Projects {
+		names = append(names, p.Project)
+	}
+	return names
+}
+
+// GetProject returns a copy of the named project, or nil if it does not exist.
+func (m *Model) GetProject(name string) *v1.ProjectGetResponse {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if p := m.FindProject(name); p != nil {
+		return proto.Clone(p).(*v1.ProjectGetResponse)
+	}
+	return nil
+}
+
+// UnclaimedDirs returns a copy of the disc directories not claimed by any project.
+func (m *Model) UnclaimedDirs() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.Unclaimed)
+}
+
+// SearchMetadata returns copies of the catalog entries matching partialTitle.
+func (m *Model) SearchMetadata(partialTitle string) []*v1.MovieSearchResult {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var results []*v1.MovieSearchResult
+	for r := range m.FindMetadata(partialTitle) {
+		results = append(results, proto.Clone(r).(*v1.MovieSearchResult))
+	}
+	return results
+}
+
+// ProjectState returns the lifecycle state of the named project, or false if
+// the model has never seen it.
+func (m *Model) ProjectState(name string) (ProjectState, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.FindProject(name) != nil {
+		return ProjectActive, true
+	}
+	state, ok := m.Closed[name]
+	return state, ok
+}
+
+// NewProject adds an empty project called name.  The name of a finished or
+// abandoned project may be reused, which starts a new active project.
+func (m *Model) NewProject(name string) error {
+	if err := validateProjectName(name); err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FindProject(name) != nil {
+		return connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("project already exists: %s", name))
+	}
+	delete(m.Closed, name)
+	m.Projects = append(m.Projects, &v1.ProjectGetResponse{Project: name})
+	return nil
+}
+
+// AssignDiscDirs moves dirs out of Unclaimed and onto the named project as new
+// discs waiting for thumbnails.  Either every dir is assigned or, on error,
+// none are.
+func (m *Model) AssignDiscDirs(project string, dirs []string) error {
+	if len(dirs) == 0 {
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("no dirs to assign"))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, err := m.activeProject(project)
+	if err != nil {
+		return err
+	}
+	for i, dir := range dirs {
+		if slices.Contains(dirs[:i], dir) {
+			return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("dir listed more than once: %s", dir))
+		}
+		if slices.Contains(m.Unclaimed, dir) {
+			continue
+		}
+		if owner := m.FindDiscOwner(dir); owner != nil {
+			return connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("dir %s is already claimed by project %s", dir, owner.Project))
+		}
+		return connect.NewError(connect.CodeNotFound, fmt.Errorf("dir not found: %s", dir))
+	}
+	now := m.now()
+	for _, dir := range dirs {
+		m.Unclaimed = slices.DeleteFunc(m.Unclaimed, func(d string) bool { return d == dir })
+		p.Discs = append(p.Discs, &v1.ProjectDisc{Disc: dir, ThumbState: ThumbStateWaiting})
+		m.thumbJobs = append(m.thumbJobs, thumbJob{project: project, disc: dir, since: now})
+	}
+	return nil
+}
+
+// CategorizeFiles sets the category of each referenced file.  Files can only be
+// categorized once their disc's thumbnails are done.  Either every file is
+// updated or, on error, none are.
+func (m *Model) CategorizeFiles(project string, files []*v1.FileCategory) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, err := m.activeProject(project)
+	if err != nil {
+		return err
+	}
+	targets := make([]*v1.DiscFile, len(files))
+	for i, fc := range files {
+		if !slices.Contains(validCategories, fc.Category) {
+			return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid category %q for file %s on disc %s", fc.Category, fc.File, fc.Disc))
+		}
+		d := findDisc(p, fc.Disc)
+		if d == nil {
+			return connect.NewError(connect.CodeNotFound, fmt.Errorf("disc not found: %s", fc.Disc))
+		}
+		if d.ThumbState != ThumbStateDone {
+			return connect.NewError(connect.CodeFailedPrecondition, fmt.Errorf("disc %s thumbnails are %s, not %s", d.Disc, d.ThumbState, ThumbStateDone))
+		}
+		f := findDiscFile(d, fc.File)
+		if f == nil {
+			return connect.NewError(connect.CodeNotFound, fmt.Errorf("file not found: %s on disc %s", fc.File, fc.Disc))
+		}
+		targets[i] = f
+	}
+	for i, f := range targets {
+		f.Category = files[i].Category
+	}
+	return nil
+}
+
+// SetMetadata records the catalog entry with the given id as the project's
+// metadata, replacing any earlier choice.
+func (m *Model) SetMetadata(project, id string) error {
+	if id == "" {
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("metadata id must not be empty"))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, err := m.activeProject(project)
+	if err != nil {
+		return err
+	}
+	md := m.FindMetadataByID(id)
+	if md == nil {
+		return connect.NewError(connect.CodeNotFound, fmt.Errorf("movie not found: %s", id))
+	}
+	p.SearchResult = proto.Clone(md).(*v1.MovieSearchResult)
+	return nil
+}
+
+// FinishProject moves an active project to the finished state, removing it
+// from Projects.  It fails with FailedPrecondition, listing every rule that is
+// broken, unless the project is ready to finish.
+func (m *Model) FinishProject(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, err := m.activeProject(name)
+	if err != nil {
+		return err
+	}
+	if err := checkFinishable(p); err != nil {
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	}
+	m.closeProject(p, ProjectFinished)
+	return nil
+}
+
+// AbandonProject moves an active project to the abandoned state, removing it
+// from Projects and returning its disc directories to Unclaimed.
+func (m *Model) AbandonProject(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, err := m.activeProject(name)
+	if err != nil {
+		return err
+	}
+	for _, d := range p.Discs {
+		m.Unclaimed = append(m.Unclaimed, d.Disc)
+	}
+	m.closeProject(p, ProjectAbandoned)
+	return nil
+}
+
+// checkFinishable returns an error describing each reason p cannot be
+// finished yet, or nil if it can.
+func checkFinishable(p *v1.ProjectGetResponse) error {
+	var errs []error
+	mainTitles := 0
+	for _, d := range p.Discs {
+		if d.ThumbState != ThumbStateDone {
+			errs = append(errs, fmt.Errorf("disc %s thumbnails are %s, not %s", d.Disc, d.ThumbState, ThumbStateDone))
+			continue
+		}
+		for _, f := range d.DiscFiles {
+			switch f.Category {
+			case CategoryUnset:
+				errs = append(errs, fmt.Errorf("file %s on disc %s is not categorized", f.File, d.Disc))
+			case CategoryMainTitle:
+				mainTitles++
+			}
+		}
+	}
+	if mainTitles != 1 {
+		errs = append(errs, fmt.Errorf("project has %d %s files, want exactly 1", mainTitles, CategoryMainTitle))
+	}
+	if p.SearchResult == nil {
+		errs = append(errs, errors.New("project has no metadata set"))
+	}
+	return errors.Join(errs...)
+}
+
+// validateProjectName rejects names that could not be used as a directory name.
+func validateProjectName(name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return errors.New("project name must not be empty")
+	case name != strings.TrimSpace(name):
+		return fmt.Errorf("project name must not start or end with whitespace: %q", name)
+	case name == "." || name == "..":
+		return fmt.Errorf("invalid project name: %q", name)
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("project name must not contain path separators: %q", name)
+	case strings.ContainsFunc(name, func(r rune) bool { return !unicode.IsPrint(r) }):
+		return fmt.Errorf("project name must not contain control characters: %q", name)
+	}
+	return nil
+}
+
+// data is the built-in model, served unless main loads a fixture file.  It is
+// only ever cloned, never served directly, so that it can be restored.
 var data = &Model{
 	Projects: []*v1.ProjectGetResponse{
 		{
@@ -96,6 +627,7 @@ var data = &Model{
 	Unclaimed: []string{"Unclaimed1", "Unclaimed 2"},
 	Metadata: []*v1.MovieSearchResult{
 		{
+			Id:            "1001",
 			Title:         "Movie 1",
 			OriginalTitle: "Original Movie 1",
 			ReleaseDate:   "2023-01-01",
@@ -103,6 +635,7 @@ var data = &Model{
 			Overview:      "An action-packed adventure movie.",
 		},
 		{
+			Id:            "1002",
 			Title:         "Movie 2",
 			OriginalTitle: "Original Movie 2",
 			ReleaseDate:   "2023-01-02",
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"connectrpc.com/connect"
+)
+
+func TestModelNewProject(t *testing.T) {
+	m := &Model{
+		Projects: []*v1.ProjectGetResponse{{Project: "Existing"}},
+	}
+
+	tests := []struct {
+		name     string
+		project  string
+		wantCode connect.Code // zero means success
+	}{
+		{name: "new", project: "New Project"},
+		{name: "duplicate", project: "Existing", wantCode: connect.CodeAlreadyExists},
+		{name: "empty", project: "", wantCode: connect.CodeInvalidArgument},
+		{name: "blank", project: "   ", wantCode: connect.CodeInvalidArgument},
+		{name: "padded", project: " Padded", wantCode: connect.CodeInvalidArgument},
+		{name: "separator", project: "a/b", wantCode: connect.CodeInvalidArgument},
+		{name: "dot dot", project: "..", wantCode: connect.CodeInvalidArgument},
+		{name: "control", project: "a\nb", wantCode: connect.CodeInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.NewProject(tt.project)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if connect.CodeOf(err) != tt.wantCode {
+				t.Fatalf("Expected code %v, got: %v", tt.wantCode, err)
+			}
+		})
+	}
+
+	if got, want := m.ProjectNames(), []string{"Existing", "New Project"}; !slices.Equal(got, want) {
+		t.Errorf("Expected projects %v, got %v", want, got)
+	}
+	if p := m.GetProject("New Project"); p == nil || len(p.Discs) != 0 {
+		t.Errorf("Expected empty project 'New Project', got %v", p)
+	}
+}
+
+func TestModelConcurrentNewProject(t *testing.T) {
+	m := &Model{}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := m.NewProject(fmt.Sprintf("Project %d", i)); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			m.ProjectNames()
+		}()
+	}
+	wg.Wait()
+
+	if got := len(m.ProjectNames()); got != 20 {
+		t.Errorf("Expected 20 projects, got %d", got)
+	}
+}
+
+func TestModelAssignDiscDirs(t *testing.T) {
+	newModel := func() *Model {
+		return &Model{
+			Projects: []*v1.ProjectGetResponse{
+				{Project: "Target"},
+				{Project: "Other", Discs: []*v1.ProjectDisc{{Disc: "Claimed", ThumbState: ThumbStateDone}}},
+			},
+			Unclaimed: []string{"Dir A", "Dir B", "Dir C"},
+		}
+	}
+
+	t.Run("success", func(t *testing.T) {
+		m := newModel()
+		if err := m.AssignDiscDirs("Target", []string{"Dir A", "Dir C"}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got, want := m.UnclaimedDirs(), []string{"Dir B"}; !slices.Equal(got, want) {
+			t.Errorf("Expected unclaimed %v, got %v", want, got)
+		}
+		p := m.GetProject("Target")
+		if len(p.Discs) != 2 {
+			t.Fatalf("Expected 2 discs, got %v", p.Discs)
+		}
+		for i, want := range []string{"Dir A", "Dir C"} {
+			if p.Discs[i].Disc != want || p.Discs[i].ThumbState != ThumbStateWaiting {
+				t.Errorf("Expected disc %q waiting for thumbs, got %v", want, p.Discs[i])
+			}
+		}
+	})
+
+	errorTests := []struct {
+		name     string
+		project  string
+		dirs     []string
+		wantCode connect.Code
+	}{
+		{name: "no dirs", project: "Target", wantCode: connect.CodeInvalidArgument},
+		{name: "unknown project", project: "Missing", dirs: []string{"Dir A"}, wantCode: connect.CodeNotFound},
+		{name: "unknown dir", project: "Target", dirs: []string{"Dir A", "Missing"}, wantCode: connect.CodeNotFound},
+		{name: "claimed dir", project: "Target", dirs: []string{"Dir A", "Claimed"}, wantCode: connect.CodeFailedPrecondition},
+		{name: "repeated dir", project: "Target", dirs: []string{"Dir A", "Dir A"}, wantCode: connect.CodeInvalidArgument},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newModel()
+			err := m.AssignDiscDirs(tt.project, tt.dirs)
+			if connect.CodeOf(err) != tt.wantCode {
+				t.Fatalf("Expected code %v, got: %v", tt.wantCode, err)
+			}
+			// A failed assignment must leave the model untouched.
+			if got := m.UnclaimedDirs(); len(got) != 3 {
+				t.Errorf("Expected unclaimed dirs to be unchanged, got %v", got)
+			}
+			if p := m.GetProject("Target"); len(p.Discs) != 0 {
+				t.Errorf("Expected no discs on target project, got %v", p.Discs)
+			}
+		})
+	}
+}
+
+func TestModelCategorizeFiles(t *testing.T) {
+	newModel := func() *Model {
+		return &Model{
+			Projects: []*v1.ProjectGetResponse{
+				{
+					Project: "Project",
+					Discs: []*v1.ProjectDisc{
+						{Disc: "Waiting", ThumbState: ThumbStateWaiting},
+						{
+							Disc:       "Done",
+							ThumbState: ThumbStateDone,
+							DiscFiles: []*v1.DiscFile{
+								{File: "a.mkv"},
+								{File: "b.mkv", Category: CategoryTrash},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	categories := func(m *Model) []string {
+		var got []string
+		for _, f := range m.GetProject("Project").Discs[1].DiscFiles {
+			got = append(got, f.Category)
+		}
+		return got
+	}
+
+	t.Run("success", func(t *testing.T) {
+		m := newModel()
+		err := m.CategorizeFiles("Project", []*v1.FileCategory{
+			{Disc: "Done", File: "a.mkv", Category: CategoryMainTitle},
+			{Disc: "Done", File: "b.mkv", Category: CategoryUnset},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got, want := categories(m), []string{CategoryMainTitle, CategoryUnset}; !slices.Equal(got, want) {
+			t.Errorf("Expected categories %q, got %q", want, got)
+		}
+	})
+
+	errorTests := []struct {
+		name     string
+		project  string
+		file     *v1.FileCategory
+		wantCode connect.Code
+	}{
+		{name: "unknown project", project: "Missing", file: &v1.FileCategory{Disc: "Done", File: "a.mkv"}, wantCode: connect.CodeNotFound},
+		{name: "unknown disc", project: "Project", file: &v1.FileCategory{Disc: "Missing", File: "a.mkv"}, wantCode: connect.CodeNotFound},
+		{name: "unknown file", project: "Project", file: &v1.FileCategory{Disc: "Done", File: "missing.mkv"}, wantCode: connect.CodeNotFound},
+		{name: "thumbs not done", project: "Project", file: &v1.FileCategory{Disc: "Waiting", File: "a.mkv"}, wantCode: connect.CodeFailedPrecondition},
+		{name: "invalid category", project: "Project", file: &v1.FileCategory{Disc: "Done", File: "a.mkv", Category: "bogus"}, wantCode: connect.CodeInvalidArgument},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newModel()
+			// The valid first entry must not be applied when the second fails.
+			err := m.CategorizeFiles(tt.project, []*v1.FileCategory{
+				{Disc: "Done", File: "b.mkv", Category: CategoryExtra},
+				tt.file,
+			})
+			if connect.CodeOf(err) != tt.wantCode {
+				t.Fatalf("Expected code %v, got: %v", tt.wantCode, err)
+			}
+			if got, want := categories(m), []string{CategoryUnset, CategoryTrash}; !slices.Equal(got, want) {
+				t.Errorf("Expected categories to be unchanged, got %q", got)
+			}
+		})
+	}
+}
+
+func TestModelSetMetadata(t *testing.T) {
+	m := &Model{
+		Projects: []*v1.ProjectGetResponse{{Project: "Project"}},
+		Metadata: []*v1.MovieSearchResult{
+			{Id: "1", Title: "First"},
+			{Id: "2", Title: "Second"},
+		},
+	}
+
+	if err := m.SetMetadata("Project", "2"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := m.GetProject("Project").SearchResult; got.GetTitle() != "Second" {
+		t.Errorf("Expected metadata 'Second', got %v", got)
+	}
+
+	errorTests := []struct {
+		name     string
+		project  string
+		id       string
+		wantCode connect.Code
+	}{
+		{name: "unknown project", project: "Missing", id: "1", wantCode: connect.CodeNotFound},
+		{name: "unknown movie", project: "Project", id: "3", wantCode: connect.CodeNotFound},
+		{name: "empty id", project: "Project", id: "", wantCode: connect.CodeInvalidArgument},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.SetMetadata(tt.project, tt.id)
+			if connect.CodeOf(err) != tt.wantCode {
+				t.Fatalf("Expected code %v, got: %v", tt.wantCode, err)
+			}
+			if got := m.GetProject("Project").SearchResult; got.GetTitle() != "Second" {
+				t.Errorf("Expected metadata to be unchanged, got %v", got)
+			}
+		})
+	}
+}
+
+func TestModelFinishProject(t *testing.T) {
+	ready := func() *v1.ProjectGetResponse {
+		return &v1.ProjectGetResponse{
+			Project: "Project",
+			Discs: []*v1.ProjectDisc{
+				{
+					Disc:       "Disc",
+					ThumbState: ThumbStateDone,
+					DiscFiles: []*v1.DiscFile{
+						{File: "a.mkv", Category: CategoryMainTitle},
+						{File: "b.mkv", Category: CategoryTrash},
+					},
+				},
+			},
+			SearchResult: &v1.MovieSearchResult{Id: "1"},
+		}
+	}
+
+	t.Run("success", func(t *testing.T) {
+		m := &Model{Projects: []*v1.ProjectGetResponse{ready(), {Project: "Other"}}}
+		if err := m.FinishProject("Project"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got, want := m.ProjectNames(), []string{"Other"}; !slices.Equal(got, want) {
+			t.Errorf("Expected projects %v, got %v", want, got)
+		}
+		if state, _ := m.ProjectState("Project"); state != ProjectFinished {
+			t.Errorf("Expected project to be %s, got %q", ProjectFinished, state)
+		}
+
+		// Finished projects cannot be changed or finished again.
+		err := m.FinishProject("Project")
+		if connect.CodeOf(err) != connect.CodeFailedPrecondition {
+			t.Errorf("Expected FailedPrecondition for finished project, got: %v", err)
+		}
+		err = m.SetMetadata("Project", "1")
+		if connect.CodeOf(err) != connect.CodeFailedPrecondition {
+			t.Errorf("Expected FailedPrecondition for finished project, got: %v", err)
+		}
+
+		// The name can be reused for a new project.
+		if err := m.NewProject("Project"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if state, _ := m.ProjectState("Project"); state != ProjectActive {
+			t.Errorf("Expected project to be %s, got %q", ProjectActive, state)
+		}
+	})
+
+	errorTests := []struct {
+		name    string
+		modify  func(p *v1.ProjectGetResponse)
+		wantErr string
+	}{
+		{
+			name: "thumbs not done",
+			modify: func(p *v1.ProjectGetResponse) {
+				p.Discs = append(p.Discs, &v1.ProjectDisc{Disc: "New", ThumbState: ThumbStateWaiting})
+			},
+			wantErr: "disc New thumbnails are waiting",
+		},
+		{
+			name:    "uncategorized file",
+			modify:  func(p *v1.ProjectGetResponse) { p.Discs[0].DiscFiles[1].Category = CategoryUnset },
+			wantErr: "file b.mkv on disc Disc is not categorized",
+		},
+		{
+			name:    "no main title",
+			modify:  func(p *v1.ProjectGetResponse) { p.Discs[0].DiscFiles[0].Category = CategoryExtra },
+			wantErr: "project has 0 main_title files",
+		},
+		{
+			name:    "two main titles",
+			modify:  func(p *v1.ProjectGetResponse) { p.Discs[0].DiscFiles[1].Category = CategoryMainTitle },
+			wantErr: "project has 2 main_title files",
+		},
+		{
+			name:    "no metadata",
+			modify:  func(p *v1.ProjectGetResponse) { p.SearchResult = nil },
+			wantErr: "project has no metadata set",
+		},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := ready()
+			tt.modify(p)
+			m := &Model{Projects: []*v1.ProjectGetResponse{p}}
+			err := m.FinishProject("Project")
+			if connect.CodeOf(err) != connect.CodeFailedPrecondition {
+				t.Fatalf("Expected FailedPrecondition, got: %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error to contain %q, got: %v", tt.wantErr, err)
+			}
+			if state, _ := m.ProjectState("Project"); state != ProjectActive {
+				t.Errorf("Expected project to stay %s, got %q", ProjectActive, state)
+			}
+		})
+	}
+
+	if err := (&Model{}).FinishProject("Missing"); connect.CodeOf(err) != connect.CodeNotFound {
+		t.Errorf("Expected NotFound for unknown project, got: %v", err)
+	}
+}
+
+func TestModelAbandonProject(t *testing.T) {
+	m := &Model{
+		Projects: []*v1.ProjectGetResponse{
+			{Project: "Project", Discs: []*v1.ProjectDisc{{Disc: "Dir A"}, {Disc: "Dir B"}}},
+			{Project: "Other"},
+		},
+		Unclaimed: []string{"Dir C"},
+	}
+
+	if err := m.AbandonProject("Project"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, want := m.ProjectNames(), []string{"Other"}; !slices.Equal(got, want) {
+		t.Errorf("Expected projects %v, got %v", want, got)
+	}
+	if got, want := m.UnclaimedDirs(), []string{"Dir C", "Dir A", "Dir B"}; !slices.Equal(got, want) {
+		t.Errorf("Expected unclaimed %v, got %v", want, got)
+	}
+	if state, _ := m.ProjectState("Project"); state != ProjectAbandoned {
+		t.Errorf("Expected project to be %s, got %q", ProjectAbandoned, state)
+	}
+
+	if err := m.AbandonProject("Project"); connect.CodeOf(err) != connect.CodeFailedPrecondition {
+		t.Errorf("Expected FailedPrecondition for abandoned project, got: %v", err)
+	}
+	if err := m.AbandonProject("Missing"); connect.CodeOf(err) != connect.CodeNotFound {
+		t.Errorf("Expected NotFound for unknown project, got: %v", err)
+	}
+}
+
+func TestModelConcurrentAssignAndAbandon(t *testing.T) {
+	var dirs []string
+	for i := range 50 {
+		dirs = append(dirs, fmt.Sprintf("Dir %d", i))
+	}
+	m := &Model{
+		Projects:  []*v1.ProjectGetResponse{{Project: "Project"}},
+		Unclaimed: slices.Clone(dirs),
+	}
+
+	var wg sync.WaitGroup
+	for _, dir := range dirs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Either outcome is fine as long as the dir is not lost.
+			m.AssignDiscDirs("Project", []string{dir})
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.AbandonProject("Project")
+	}()
+	wg.Wait()
+
+	// Every dir must end up in exactly one place.
+	got := m.UnclaimedDirs()
+	if p := m.GetProject("Project"); p != nil {
+		for _, d := range p.Discs {
+			got = append(got, d.Disc)
+		}
+	}
+	slices.Sort(got)
+	slices.Sort(dirs)
+	if !slices.Equal(got, dirs) {
+		t.Errorf("Expected each dir exactly once, got %v", got)
+	}
+}
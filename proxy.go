@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"buf.build/gen/go/krelinga/proto/connectrpc/go/krelinga/video/in/v1/inv1connect"
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"connectrpc.com/connect"
+)
+
+// ProxyService implements the ServiceHandler interface by forwarding every
+// call to an upstream backend and returning its response or error unchanged.
+type ProxyService struct {
+	upstream inv1connect.ServiceClient
+}
+
+// NewProxyService returns a ProxyService that forwards calls to upstream.
+func NewProxyService(upstream inv1connect.ServiceClient) *ProxyService {
+	return &ProxyService{upstream: upstream}
+}
+
+// forward makes the call req to upstream through call, passing on the
+// application headers in both directions.
+func forward[Req, Resp any](ctx context.Context, call func(context.Context, *connect.Request[Req]) (*connect.Response[Resp], error), req *connect.Request[Req]) (*connect.Response[Resp], error) {
+	markProxied(ctx)
+	out := connect.NewRequest(req.Msg)
+	copyHeaders(out.Header(), req.Header())
+	resp, err := call(ctx, out)
+	if err != nil {
+		return nil, err
+	}
+	in := connect.NewResponse(resp.Msg)
+	copyHeaders(in.Header(), resp.Header())
+	return in, nil
+}
+
+// Forward forwards any call to the service upstream.
+func (p *ProxyService) Forward(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+	switch req := req.(type) {
+	case *connect.Request[v1.HelloWorldRequest]:
+		return anyResponse(p.HelloWorld(ctx, req))
+	case *connect.Request[v1.ProjectListRequest]:
+		return anyResponse(p.ProjectList(ctx, req))
+	case *connect.Request[v1.ProjectNewRequest]:
+		return anyResponse(p.ProjectNew(ctx, req))
+	case *connect.Request[v1.UnclaimedDiscDirListRequest]:
+		return anyResponse(p.UnclaimedDiscDirList(ctx, req))
+	case *connect.Request[v1.ProjectAssignDiskDirsRequest]:
+		return anyResponse(p.ProjectAssignDiskDirs(ctx, req))
+	case *connect.Request[v1.ProjectGetRequest]:
+		return anyResponse(p.ProjectGet(ctx, req))
+	case *connect.Request[v1.ProjectCategorizeFilesRequest]:
+		return anyResponse(p.ProjectCategorizeFiles(ctx, req))
+	case *connect.Request[v1.MovieSearchRequest]:
+		return anyResponse(p.MovieSearch(ctx, req))
+	case *connect.Request[v1.ProjectSetMetadataRequest]:
+		return anyResponse(p.ProjectSetMetadata(ctx, req))
+	case *connect.Request[v1.ProjectFinishRequest]:
+		return anyResponse(p.ProjectFinish(ctx, req))
+	case *connect.Request[v1.ProjectAbandonRequest]:
+		return anyResponse(p.ProjectAbandon(ctx, req))
+	}
+	return nil, connect.NewError(connect.CodeUnimplemented, fmt.Errorf("cannot forward %s", req.Spec().Procedure))
+}
+
+// anyResponse returns resp as a connect.AnyResponse, without wrapping a nil
+// resp in a non-nil interface.
+func anyResponse[Resp any](resp *connect.Response[Resp], err error) (connect.AnyResponse, error) {
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// copyHeaders adds the headers in src to dst, except those that belong to the
+// protocol or the transport, which connect sets itself, and the stub's own.
+func copyHeaders(dst, src http.Header) {
+	for k, vs := range src {
+		switch k {
+		case "Accept-Encoding", "Content-Encoding", "Content-Length", "Content-Type", "Date", "Te", "Trailer", "User-Agent":
+			continue
+		}
+		if strings.HasPrefix(k, "Connect-") || strings.HasPrefix(k, "Grpc-") || strings.HasPrefix(k, "X-Stub-") {
+			continue
+		}
+		for _, v := range vs {
+			dst.Add(k, v)
+		}
+	}
+}
+
+// callSourceKey is the context key for a *callSource.
+type callSourceKey struct{}
+
+// callSource records whether a call was answered by the stub or proxied to
+// the upstream backend, for LoggingInterceptor.
+type callSource struct {
+	proxied bool
+}
+
+func withCallSource(ctx context.Context) (context.Context, *callSource) {
+	src := &callSource{}
+	return context.WithValue(ctx, callSourceKey{}, src), src
+}
+
+// markProxied records in ctx that its call was proxied upstream.
+func markProxied(ctx context.Context) {
+	if src, ok := ctx.Value(callSourceKey{}).(*callSource); ok {
+		src.proxied = true
+	}
+}
+
+// String returns "proxied" or "stubbed".
+func (s *callSource) String() string {
+	if s.proxied {
+		return "proxied"
+	}
+	return "stubbed"
+}
+
+// errPassthrough is wrapped by the errors of calls that match a Passthrough
+// mapping.
+var errPassthrough = errors.New("passthrough")
+
+// PassthroughInterceptor implements connect.Interceptor to forward the calls
+// that match a Passthrough mapping to an upstream backend.  Every other call,
+// including one that fails with Unimplemented, is the service's to answer.
+type PassthroughInterceptor struct {
+	Proxy *ProxyService
+}
+
+// WrapUnary implements the Interceptor interface for unary RPC calls
+func (i *PassthroughInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		resp, err := next(ctx, req)
+		if !errors.Is(err, errPassthrough) {
+			return resp, err
+		}
+		return i.Proxy.Forward(ctx, req)
+	}
+}
+
+// WrapStreamingClient implements the Interceptor interface for streaming client calls
+func (i *PassthroughInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next // No streaming clients in this stub service
+}
+
+// WrapStreamingHandler implements the Interceptor interface for streaming handler calls
+func (i *PassthroughInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next // No streaming handlers in this stub service
+}
+
+// HelloWorld forwards the call upstream
+func (p *ProxyService) HelloWorld(ctx context.Context, req *connect.Request[v1.HelloWorldRequest]) (*connect.Response[v1.HelloWorldResponse], error) {
+	return forward(ctx, p.upstream.HelloWorld, req)
+}
+
+// ProjectList forwards the call upstream
+func (p *ProxyService) ProjectList(ctx context.Context, req *connect.Request[v1.ProjectListRequest]) (*connect.Response[v1.ProjectListResponse], error) {
+	return forward(ctx, p.upstream.ProjectList, req)
+}
+
+// ProjectNew forwards the call upstream
+func (p *ProxyService) ProjectNew(ctx context.Context, req *connect.Request[v1.ProjectNewRequest]) (*connect.Response[v1.ProjectNewResponse], error) {
+	return forward(ctx, p.upstream.ProjectNew, req)
+}
+
+// UnclaimedDiscDirList forwards the call upstream
+func (p *ProxyService) UnclaimedDiscDirList(ctx context.Context, req *connect.Request[v1.UnclaimedDiscDirListRequest]) (*connect.Response[v1.UnclaimedDiscDirListResponse], error) {
+	return forward(ctx, p.upstream.UnclaimedDiscDirList, req)
+}
+
+// ProjectAssignDiskDirs forwards the call upstream
+func (p *ProxyService) ProjectAssignDiskDirs(ctx context.Context, req *connect.Request[v1.ProjectAssignDiskDirsRequest]) (*connect.Response[v1.ProjectAssignDiskDirsResponse], error) {
+	return forward(ctx, p.upstream.ProjectAssignDiskDirs, req)
+}
+
+// ProjectGet forwards the call upstream
+func (p *ProxyService) ProjectGet(ctx context.Context, req *connect.Request[v1.ProjectGetRequest]) (*connect.Response[v1.ProjectGetResponse], error) {
+	return forward(ctx, p.upstream.ProjectGet, req)
+}
+
+// ProjectCategorizeFiles forwards the call upstream
+func (p *ProxyService) ProjectCategorizeFiles(ctx context.Context, req *connect.Request[v1.ProjectCategorizeFilesRequest]) (*connect.Response[v1.ProjectCategorizeFilesResponse], error) {
+	return forward(ctx, p.upstream.ProjectCategorizeFiles, req)
+}
+
+// MovieSearch forwards the call upstream
+func (p *ProxyService) MovieSearch(ctx context.Context, req *connect.Request[v1.MovieSearchRequest]) (*connect.Response[v1.MovieSearchResponse], error) {
+	return forward(ctx, p.upstream.MovieSearch, req)
+}
+
+// ProjectSetMetadata forwards the call upstream
+func (p *ProxyService) ProjectSetMetadata(ctx context.Context, req *connect.Request[v1.ProjectSetMetadataRequest]) (*connect.Response[v1.ProjectSetMetadataResponse], error) {
+	return forward(ctx, p.upstream.ProjectSetMetadata, req)
+}
+
+// ProjectFinish forwards the call upstream
+func (p *ProxyService) ProjectFinish(ctx context.Context, req *connect.Request[v1.ProjectFinishRequest]) (*connect.Response[v1.ProjectFinishResponse], error) {
+	return forward(ctx, p.upstream.ProjectFinish, req)
+}
+
+// ProjectAbandon forwards the call upstream
+func (p *ProxyService) ProjectAbandon(ctx context.Context, req *connect.Request[v1.ProjectAbandonRequest]) (*connect.Response[v1.ProjectAbandonResponse], error) {
+	return forward(ctx, p.upstream.ProjectAbandon, req)
+}
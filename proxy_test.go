@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"buf.build/gen/go/krelinga/proto/connectrpc/go/krelinga/video/in/v1/inv1connect"
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"connectrpc.com/connect"
+)
+
+func TestPassthrough(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", "info")
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	upstreamStore := NewModelStore(&Model{Projects: []*v1.ProjectGetResponse{{Project: "Remote"}, {Project: "Doomed"}}}, nil)
+	_, upstreamHandler := inv1connect.NewServiceHandler(
+		NewStubService(upstreamStore),
+		connect.WithInterceptors(&SessionInterceptor{Store: upstreamStore}),
+	)
+	upstream := httptest.NewServer(upstreamHandler)
+	defer upstream.Close()
+
+	m, err := ParseFixture([]byte(`
+projects:
+  - project: Local
+mappings:
+  - procedure: /krelinga.video.in.v1.Service/ProjectGet
+    match: [{field: project, prefix: Remote}]
+    passthrough: true
+  - procedure: /krelinga.video.in.v1.Service/ProjectAbandon
+    passthrough: true
+  - procedure: /krelinga.video.in.v1.Service/ProjectList
+    sequence: [{error: {code: unimplemented, message: not yet}}]
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store := NewModelStore(m, nil)
+	journal := NewJournal(store.Clock(), 100)
+	proxy := NewProxyService(inv1connect.NewServiceClient(http.DefaultClient, upstream.URL))
+	_, handler := inv1connect.NewServiceHandler(
+		NewStubService(store),
+		connect.WithInterceptors(&LoggingInterceptor{Logger: logger, Journal: journal}, &PassthroughInterceptor{Proxy: proxy}, &SessionInterceptor{Store: store}),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	ctx := context.Background()
+	get := func(project string) error {
+		_, err := client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: project}))
+		return err
+	}
+
+	if err := get("Local"); err != nil {
+		t.Errorf("Expected the stub to serve Local, got: %v", err)
+	}
+	if err := get("Remote"); err != nil {
+		t.Errorf("Expected the passthrough mapping to forward Remote, got: %v", err)
+	}
+	if _, err := client.ProjectAbandon(ctx, connect.NewRequest(&v1.ProjectAbandonRequest{Project: "Doomed"})); err != nil {
+		t.Errorf("Expected the passthrough mapping to forward ProjectAbandon, got: %v", err)
+	}
+	if _, ok := upstreamStore.Base().ProjectState("Doomed"); !ok {
+		t.Error("Expected the upstream backend to abandon Doomed")
+	}
+	// Only passthrough mappings are forwarded: a project the model lacks, and
+	// a mapping's scripted Unimplemented, are the stub's answers.
+	if err := get("Missing"); connect.CodeOf(err) != connect.CodeNotFound {
+		t.Errorf("Expected the stub's NotFound for Missing, got: %v", err)
+	}
+	_, err = client.ProjectList(ctx, connect.NewRequest(&v1.ProjectListRequest{}))
+	if connect.CodeOf(err) != connect.CodeUnimplemented || !strings.Contains(err.Error(), "not yet") {
+		t.Errorf("Expected the mapping's Unimplemented, got: %v", err)
+	}
+
+	records := logRecords(t, &buf)
+	for i, want := range []string{"stubbed", "proxied", "proxied", "stubbed", "stubbed"} {
+		if i >= len(records) || records[i]["source"] != want {
+			t.Errorf("Expected log record %d to have source %s, got: %s", i+1, want, buf.String())
+		}
+	}
+	var proxied []bool
+	for _, e := range journal.Find("", nil) {
+		proxied = append(proxied, e.Proxied)
+	}
+	if len(proxied) != 5 || proxied[0] || !proxied[1] || !proxied[2] || proxied[3] || proxied[4] {
+		t.Errorf("Expected the journal to mark the second and third calls proxied, got %v", proxied)
+	}
+}
+
+func TestPassthroughWithoutUpstream(t *testing.T) {
+	m, err := ParseFixture([]byte(`
+mappings:
+  - procedure: /krelinga.video.in.v1.Service/ProjectList
+    passthrough: true
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store := NewModelStore(m, nil)
+	_, handler := inv1connect.NewServiceHandler(NewStubService(store), connect.WithInterceptors(&SessionInterceptor{Store: store}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	_, err = client.ProjectList(context.Background(), connect.NewRequest(&v1.ProjectListRequest{}))
+	if connect.CodeOf(err) != connect.CodeUnimplemented || !strings.Contains(err.Error(), "passes calls through") {
+		t.Errorf("Expected Unimplemented, got: %v", err)
+	}
+}
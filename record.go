@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Exchange is one recorded RPC: a request and the response or error it got.
+type Exchange struct {
+	Procedure string
+	Request   proto.Message
+	Response  proto.Message  // nil if the call failed
+	Err       *connect.Error // nil if the call succeeded
+}
+
+// exchangeJSON is the representation of an Exchange in a recording, one per
+// line, with messages in the fixture format.
+type exchangeJSON struct {
+	Procedure string          `json:"procedure"`
+	Request   json.RawMessage `json:"request"`
+	Response  json.RawMessage `json:"response,omitempty"`
+	Error     *errorJSON      `json:"error,omitempty"`
+}
+
+// Recorder implements connect.Interceptor to write every RPC it sees to a
+// recording, in the JSON Lines format that ReadRecording reads.
+type Recorder struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewRecorder returns a Recorder that writes to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// Record writes one exchange to the recording.
+func (r *Recorder) Record(x Exchange) error {
+	j := exchangeJSON{Procedure: x.Procedure}
+	var err error
+	if j.Request, err = protojson.Marshal(x.Request); err != nil {
+		return err
+	}
+	if x.Err != nil {
+		j.Error = &errorJSON{Code: x.Err.Code(), Message: x.Err.Message()}
+	} else if j.Response, err = protojson.Marshal(x.Response); err != nil {
+		return err
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.w.Write(append(b, '\n'))
+	return err
+}
+
+// WrapUnary implements the Interceptor interface for unary RPC calls
+func (r *Recorder) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		resp, err := next(ctx, req)
+		x := Exchange{Procedure: req.Spec().Procedure}
+		x.Request, _ = req.Any().(proto.Message)
+		if err != nil {
+			if !errors.As(err, &x.Err) {
+				x.Err = connect.NewError(connect.CodeUnknown, err)
+			}
+		} else {
+			x.Response, _ = resp.Any().(proto.Message)
+		}
+		if rerr := r.Record(x); rerr != nil {
+			slog.ErrorContext(ctx, "Failed to record call", "procedure", x.Procedure, "error", rerr)
+		}
+		return resp, err
+	}
+}
+
+// WrapStreamingClient implements the Interceptor interface for streaming client calls
+func (r *Recorder) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next // No streaming clients in this stub service
+}
+
+// WrapStreamingHandler implements the Interceptor interface for streaming handler calls
+func (r *Recorder) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next // No streaming handlers in this stub service
+}
+
+// ReadRecording reads the exchanges in a recording written by Recorder.
+// Blank lines are skipped.
+func ReadRecording(r io.Reader) ([]Exchange, error) {
+	var out []Exchange
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		x, err := parseExchange(b)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		out = append(out, x)
+	}
+	return out, scanner.Err()
+}
+
+// LoadRecording reads the exchanges in the recording at path.
+func LoadRecording(path string) ([]Exchange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	xs, err := ReadRecording(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return xs, nil
+}
+
+func parseExchange(b []byte) (Exchange, error) {
+	var j exchangeJSON
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&j); err != nil {
+		return Exchange{}, err
+	}
+	x := Exchange{Procedure: j.Procedure}
+	method, err := findMethod(j.Procedure)
+	if err != nil {
+		return x, fmt.Errorf("procedure: %w", err)
+	}
+	if x.Request, err = newMessage(method.Input()); err != nil {
+		return x, err
+	}
+	if err := protojson.Unmarshal(j.Request, x.Request); err != nil {
+		return x, fmt.Errorf("request: %w", err)
+	}
+	switch {
+	case j.Error != nil && j.Response != nil:
+		return x, errors.New("response and error cannot both be set")
+	case j.Error != nil:
+		if j.Error.Code < connect.CodeCanceled || j.Error.Code > connect.CodeUnauthenticated {
+			return x, fmt.Errorf("error.code: unknown code %v", j.Error.Code)
+		}
+		x.Err = connect.NewError(j.Error.Code, errors.New(j.Error.Message))
+	default:
+		if x.Response, err = newMessage(method.Output()); err != nil {
+			return x, err
+		}
+		if j.Response != nil {
+			if err := protojson.Unmarshal(j.Response, x.Response); err != nil {
+				return x, fmt.Errorf("response: %w", err)
+			}
+		}
+	}
+	return x, nil
+}
+
+// RecordingMappings returns mappings that answer each recorded request as it
+// was answered when it was recorded.  Exchanges with equal requests become a
+// single mapping whose sequence replays their replies in order.  The mappings
+// are Literal, since recorded strings are data, not templates.
+func RecordingMappings(xs []Exchange) []*RequestResponseMapping {
+	var out []*RequestResponseMapping
+	for _, x := range xs {
+		reply := MappingReply{Response: x.Response}
+		if x.Err != nil {
+			reply = MappingReply{Code: x.Err.Code(), Message: x.Err.Message()}
+		}
+		var mp *RequestResponseMapping
+		for _, m := range out {
+			if m.Procedure == x.Procedure && proto.Equal(m.Request, x.Request) {
+				mp = m
+				break
+			}
+		}
+		if mp == nil {
+			mp = &RequestResponseMapping{Procedure: x.Procedure, Request: x.Request, Exact: true, Literal: true}
+			out = append(out, mp)
+		}
+		mp.Sequence = append(mp.Sequence, reply)
+	}
+	return out
+}
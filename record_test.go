@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"buf.build/gen/go/krelinga/proto/connectrpc/go/krelinga/video/in/v1/inv1connect"
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+)
+
+// headerInterceptor records the Authorization header of the last call.
+type headerInterceptor struct {
+	connect.Interceptor
+	got string
+}
+
+func (h *headerInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		h.got = req.Header().Get("Authorization")
+		return next(ctx, req)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	// The upstream backend is another stub, serving the built-in data.
+	store := NewModelStore(data.Clone(), nil)
+	headers := &headerInterceptor{}
+	_, upstreamHandler := inv1connect.NewServiceHandler(
+		NewStubService(store),
+		connect.WithInterceptors(headers, &SessionInterceptor{Store: store}),
+	)
+	upstream := httptest.NewServer(upstreamHandler)
+	defer upstream.Close()
+
+	var recording bytes.Buffer
+	_, proxyHandler := inv1connect.NewServiceHandler(
+		NewProxyService(inv1connect.NewServiceClient(http.DefaultClient, upstream.URL)),
+		connect.WithInterceptors(NewRecorder(&recording)),
+	)
+	proxy := httptest.NewServer(proxyHandler)
+	defer proxy.Close()
+
+	client := inv1connect.NewServiceClient(http.DefaultClient, proxy.URL)
+	ctx := context.Background()
+	req := connect.NewRequest(&v1.ProjectGetRequest{Project: "Name With Spaces"})
+	req.Header().Set("Authorization", "Bearer token")
+	got, err := client.ProjectGet(ctx, req)
+	if err != nil {
+		t.Fatalf("ProjectGet call failed: %v", err)
+	}
+	want := store.Base().GetProject("Name With Spaces")
+	if !proto.Equal(got.Msg, want) {
+		t.Errorf("Expected the upstream response %v, got %v", want, got.Msg)
+	}
+	if headers.got != "Bearer token" {
+		t.Errorf("Expected the Authorization header to be forwarded, got %q", headers.got)
+	}
+	_, err = client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: "Missing"}))
+	if connect.CodeOf(err) != connect.CodeNotFound {
+		t.Fatalf("Expected the upstream NotFound, got: %v", err)
+	}
+	client.ProjectNew(ctx, connect.NewRequest(&v1.ProjectNewRequest{Name: "Added"}))
+	client.ProjectNew(ctx, connect.NewRequest(&v1.ProjectNewRequest{Name: "Added"}))
+
+	if n := strings.Count(recording.String(), "\n"); n != 4 {
+		t.Fatalf("Expected 4 recorded calls, got %d:\n%s", n, recording.String())
+	}
+	xs, err := ReadRecording(&recording)
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+
+	// Replayed, the recording answers the same calls the same way, even once
+	// the model has changed.
+	s := NewStubService(NewModelStore(&Model{}, nil))
+	s.mappings = RecordingMappings(xs)
+	_, handler := inv1connect.NewServiceHandler(s)
+	replay := httptest.NewServer(handler)
+	defer replay.Close()
+
+	client = inv1connect.NewServiceClient(http.DefaultClient, replay.URL)
+	got, err = client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: "Name With Spaces"}))
+	if err != nil {
+		t.Fatalf("Replayed ProjectGet call failed: %v", err)
+	}
+	if !proto.Equal(got.Msg, want) {
+		t.Errorf("Expected the recorded response %v, got %v", want, got.Msg)
+	}
+	_, err = client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: "Missing"}))
+	if connect.CodeOf(err) != connect.CodeNotFound || !strings.Contains(err.Error(), "Missing") {
+		t.Errorf("Expected the recorded NotFound, got: %v", err)
+	}
+	if _, err := client.ProjectNew(ctx, connect.NewRequest(&v1.ProjectNewRequest{Name: "Added"})); err != nil {
+		t.Errorf("Expected the first ProjectNew to succeed, got: %v", err)
+	}
+	if _, err := client.ProjectNew(ctx, connect.NewRequest(&v1.ProjectNewRequest{Name: "Added"})); connect.CodeOf(err) != connect.CodeAlreadyExists {
+		t.Errorf("Expected the second ProjectNew to fail as recorded, got: %v", err)
+	}
+}
+
+func TestRecordingIsNotTemplated(t *testing.T) {
+	// Recorded strings that look like templates are replayed as they are.
+	var recording bytes.Buffer
+	r := NewRecorder(&recording)
+	for _, x := range []Exchange{
+		{
+			Procedure: inv1connect.ServiceProjectGetProcedure,
+			Request:   &v1.ProjectGetRequest{Project: "Braces"},
+			Response:  &v1.ProjectGetResponse{Project: "Braces", Discs: []*v1.ProjectDisc{{Disc: "{{ .Missing }} {{"}}},
+		},
+		{
+			Procedure: inv1connect.ServiceProjectGetProcedure,
+			Request:   &v1.ProjectGetRequest{Project: "Error"},
+			Err:       connect.NewError(connect.CodeNotFound, errors.New("no project {{ .Request.project }}")),
+		},
+	} {
+		if err := r.Record(x); err != nil {
+			t.Fatalf("Failed to record: %v", err)
+		}
+	}
+	xs, err := ReadRecording(&recording)
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+
+	s := NewStubService(NewModelStore(&Model{}, nil))
+	s.mappings = RecordingMappings(xs)
+	_, handler := inv1connect.NewServiceHandler(s)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	ctx := context.Background()
+	got, err := client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: "Braces"}))
+	if err != nil {
+		t.Fatalf("Replayed ProjectGet call failed: %v", err)
+	}
+	if want := "{{ .Missing }} {{"; len(got.Msg.Discs) != 1 || got.Msg.Discs[0].Disc != want {
+		t.Errorf("Expected the disc %q, got %v", want, got.Msg)
+	}
+	_, err = client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: "Error"}))
+	var cerr *connect.Error
+	if !errors.As(err, &cerr) || cerr.Code() != connect.CodeNotFound || cerr.Message() != "no project {{ .Request.project }}" {
+		t.Errorf("Expected the recorded NotFound, got: %v", err)
+	}
+}
+
+func TestReadRecording(t *testing.T) {
+	tests := []struct {
+		name      string
+		recording string
+		wantErr   string
+	}{
+		{name: "unknown procedure", recording: `{"procedure": "/krelinga.video.in.v1.Service/Nope", "request": {}}`, wantErr: "line 1: procedure"},
+		{name: "wrong request type", recording: "\n" + `{"procedure": "/krelinga.video.in.v1.Service/ProjectGet", "request": {"name": "x"}}`, wantErr: "line 2: request"},
+		{name: "response and error", recording: `{"procedure": "/krelinga.video.in.v1.Service/ProjectList", "request": {}, "response": {}, "error": {"code": "internal"}}`, wantErr: "cannot both"},
+		{name: "unknown code", recording: `{"procedure": "/krelinga.video.in.v1.Service/ProjectList", "request": {}, "error": {"code": "broken"}}`, wantErr: "broken"},
+		{name: "unknown field", recording: `{"procedure": "/krelinga.video.in.v1.Service/ProjectList", "request": {}, "reply": {}}`, wantErr: "reply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadRecording(strings.NewReader(tt.recording))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected an error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// fixtureWatcher reloads the fixture file at path as the base model of store
+// whenever its contents change.  New contents are only loaded once they are
+// the same on two polls in a row, so that a file an editor is still writing is
+// not loaded half-written.
+type fixtureWatcher struct {
+	path     string
+	store    *ModelStore
+	contents settledContents
+}
+
+// newFixtureWatcher returns a fixtureWatcher that detects changes relative to
+// the file at path as it is now, which is assumed to be what the base model of
+// store was loaded from.
+func newFixtureWatcher(path string, store *ModelStore) *fixtureWatcher {
+	initial, _ := os.ReadFile(path)
+	return &fixtureWatcher{path: path, store: store, contents: settledContents{last: initial}}
+}
+
+// run polls the file every interval until ctx is done.  If new contents fail
+// to load, the previous model is kept and the reason is logged.
+func (w *fixtureWatcher) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reloaded, err := w.poll()
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to reload fixture, keeping previous model", "path", w.path, "error", err)
+		} else if reloaded {
+			slog.InfoContext(ctx, "Reloaded fixture", "path", w.path)
+		}
+	}
+}
+
+// poll reads the file once, and loads it if its contents have settled on a
+// change.  Returns whether it loaded them, or why they failed to load.
+func (w *fixtureWatcher) poll() (bool, error) {
+	b, err := os.ReadFile(w.path)
+	if err != nil {
+		// Editors often replace files by renaming, so the file may briefly
+		// be missing; try again on the next poll.
+		return false, nil
+	}
+	if !w.contents.changed(b) {
+		return false, nil
+	}
+	m, err := ParseFixture(b)
+	if err != nil {
+		return false, err
+	}
+	w.store.Replace("", m)
+	return true, nil
+}
+
+// settledContents tracks the contents of a file across polls to tell when
+// they have changed and then stayed the same for a poll.
+type settledContents struct {
+	last    []byte // the contents last reported as changed
+	pending []byte // changed contents seen on the previous poll, if seen
+	seen    bool
+}
+
+// changed reports whether b, the contents read by a poll, are new contents
+// that were also read by the previous poll.
+func (c *settledContents) changed(b []byte) bool {
+	if bytes.Equal(b, c.last) {
+		c.seen = false
+		return false
+	}
+	if !c.seen || !bytes.Equal(b, c.pending) {
+		c.pending, c.seen = b, true
+		return false
+	}
+	c.last, c.seen = b, false
+	return true
+}
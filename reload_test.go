@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFixtureWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixture.yaml")
+	write := func(contents string) {
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Failed to write fixture: %v", err)
+		}
+	}
+	write("unclaimed: [Before]\n")
+
+	initial, err := LoadFixture(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store := NewModelStore(initial, nil)
+	w := newFixtureWatcher(path, store)
+	poll := func(wantReloaded, wantErr bool) {
+		t.Helper()
+		reloaded, err := w.poll()
+		if reloaded != wantReloaded || (err != nil) != wantErr {
+			t.Fatalf("Expected reloaded %v and error %v, got %v, %v", wantReloaded, wantErr, reloaded, err)
+		}
+	}
+
+	poll(false, false)
+	write("unclaimed: [After]\n")
+	poll(false, false)
+	poll(true, false)
+	if got := store.Base().UnclaimedDirs(); !slices.Equal(got, []string{"After"}) {
+		t.Errorf("Expected unclaimed [After], got %v", got)
+	}
+
+	// A broken file must leave the last good model in place.
+	reloaded := store.Base()
+	write("unclaimed: {broken\n")
+	poll(false, false)
+	poll(false, true)
+	poll(false, false)
+	if store.Base() != reloaded {
+		t.Fatal("Expected the previous model to be kept after a failed reload")
+	}
+
+	// A missing file is skipped until it is back.
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Failed to remove fixture: %v", err)
+	}
+	poll(false, false)
+	write("unclaimed: [Fixed]\n")
+	poll(false, false)
+	poll(true, false)
+	if got := store.Base().UnclaimedDirs(); !slices.Equal(got, []string{"Fixed"}) {
+		t.Errorf("Expected unclaimed [Fixed], got %v", got)
+	}
+
+	// run polls on its own until it is stopped.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.run(ctx, time.Millisecond)
+		close(done)
+	}()
+	write("unclaimed: [Running]\n")
+	deadline := time.Now().Add(2 * time.Second)
+	for !slices.Equal(store.Base().UnclaimedDirs(), []string{"Running"}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected unclaimed [Running], got %v", store.Base().UnclaimedDirs())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func TestSettledContents(t *testing.T) {
+	c := settledContents{last: []byte("unclaimed: [A, B]\n")}
+	for i, tt := range []struct {
+		read string
+		want bool
+	}{
+		{"unclaimed: [A, B]\n", false},
+		// A write in progress that happens to parse is not loaded...
+		{"unclaimed: [A]\n", false},
+		// ...as long as the file keeps changing.
+		{"unclaimed: [A, B, C]\n", false},
+		{"unclaimed: [A, B, C]\n", true},
+		{"unclaimed: [A, B, C]\n", false},
+		// Changing back before settling is no change at all.
+		{"unclaimed: [A]\n", false},
+		{"unclaimed: [A, B, C]\n", false},
+		{"unclaimed: [A, B, C]\n", false},
+	} {
+		if got := c.changed([]byte(tt.read)); got != tt.want {
+			t.Errorf("Poll %d of %q: expected changed %v, got %v", i+1, tt.read, tt.want, got)
+		}
+	}
+}
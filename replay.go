@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Replay answers calls from a recording, matching each call to a recorded
+// exchange with the same procedure and an equal request.  Every exchange
+// answers one call.
+type Replay struct {
+	// Strict fails calls that match no recorded exchange with NotFound,
+	// rather than letting them fall through to the mappings and the model.
+	Strict bool
+
+	// Ordered requires calls to arrive in the order they were recorded.  A
+	// call that is not the next one recorded does not match.  Otherwise calls
+	// may come in any order, and once the exchanges for a request are used
+	// up, the last of them answers it again.
+	Ordered bool
+
+	mu        sync.Mutex
+	exchanges []Exchange
+	used      []bool
+	next      int // for Ordered, the next exchange to answer
+}
+
+// NewReplay returns a Replay of the exchanges xs.
+func NewReplay(xs []Exchange) *Replay {
+	return &Replay{exchanges: xs, used: make([]bool, len(xs))}
+}
+
+// Reset makes every exchange available again, starting over from the first.
+func (r *Replay) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clear(r.used)
+	r.next = 0
+}
+
+// Remaining returns how many exchanges have not answered a call yet.
+func (r *Replay) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, used := range r.used {
+		if !used {
+			n++
+		}
+	}
+	return n
+}
+
+// answer returns the exchange that answers a call to procedure with request
+// req, and marks it used.  It returns nil if none does and the call should
+// fall through, or an error if r is strict.  An exchange whose response is not
+// a respType, the call's response type, is an Internal error and stays
+// unused.
+func (r *Replay) answer(procedure string, req proto.Message, respType protoreflect.FullName) (*Exchange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	matches := func(i int) bool {
+		x := &r.exchanges[i]
+		return x.Procedure == procedure && proto.Equal(x.Request, req)
+	}
+	found := -1
+	if r.Ordered {
+		if r.next < len(r.exchanges) && matches(r.next) {
+			found = r.next
+		}
+	} else {
+		for i := range r.exchanges {
+			if matches(i) {
+				found = i
+				if !r.used[i] {
+					break
+				}
+			}
+		}
+	}
+	if found < 0 {
+		if !r.Strict {
+			return nil, nil
+		}
+		return nil, connect.NewError(connect.CodeNotFound, r.missError(procedure, req))
+	}
+	x := &r.exchanges[found]
+	if x.Err == nil && (x.Response == nil || x.Response.ProtoReflect().Descriptor().FullName() != respType) {
+		return nil, connect.NewError(connect.CodeInternal,
+			fmt.Errorf("replay: recorded call %d to %s has a %s response, want %s", found+1, procedure, responseTypeName(x.Response), respType))
+	}
+	r.used[found] = true
+	if r.Ordered {
+		r.next++
+	}
+	return x, nil
+}
+
+// missError describes why a call matched no exchange.  Callers must hold
+// r.mu.
+func (r *Replay) missError(procedure string, req proto.Message) error {
+	b, _ := protojson.Marshal(req)
+	if !r.Ordered {
+		return fmt.Errorf("replay: no recorded call to %s with request %s", procedure, b)
+	}
+	if r.next >= len(r.exchanges) {
+		return fmt.Errorf("replay: got a call to %s with request %s after all %d recorded calls", procedure, b, len(r.exchanges))
+	}
+	x := &r.exchanges[r.next]
+	want, _ := protojson.Marshal(x.Request)
+	return fmt.Errorf("replay: got a call to %s with request %s, want recorded call %d to %s with request %s", procedure, b, r.next+1, x.Procedure, want)
+}
+
+// responseTypeName names the type of m in errors.
+func responseTypeName(m proto.Message) protoreflect.FullName {
+	if m == nil {
+		return "nil"
+	}
+	return m.ProtoReflect().Descriptor().FullName()
+}
+
+// findReplayedResponse returns the recorded reply to req if s replays a
+// recording: a response, or an error.  Returns nil and no error if the call
+// should fall through to the mappings and the model.
+func findReplayedResponse[Resp any](s *StubService, req connect.AnyRequest) (*connect.Response[Resp], error) {
+	msg, ok := req.Any().(proto.Message)
+	if s.replay == nil || !ok {
+		return nil, nil
+	}
+	zero, ok := any(new(Resp)).(proto.Message)
+	if !ok {
+		return nil, nil
+	}
+	x, err := s.replay.answer(req.Spec().Procedure, msg, zero.ProtoReflect().Descriptor().FullName())
+	if x == nil || err != nil {
+		return nil, err
+	}
+	if x.Err != nil {
+		return nil, connect.NewError(x.Err.Code(), errors.New(x.Err.Message()))
+	}
+	resp, ok := any(proto.Clone(x.Response)).(*Resp)
+	if !ok {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("replay: recorded %s response is a %T", responseTypeName(x.Response), x.Response))
+	}
+	return connect.NewResponse(resp), nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"buf.build/gen/go/krelinga/proto/connectrpc/go/krelinga/video/in/v1/inv1connect"
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"connectrpc.com/connect"
+)
+
+const replayRecording = `
+{"procedure": "/krelinga.video.in.v1.Service/ProjectGet", "request": {"project": "P"}, "response": {"project": "P", "discs": [{"disc": "D", "thumbState": "waiting"}]}}
+{"procedure": "/krelinga.video.in.v1.Service/ProjectGet", "request": {"project": "P"}, "response": {"project": "P", "discs": [{"disc": "D", "thumbState": "done"}]}}
+{"procedure": "/krelinga.video.in.v1.Service/ProjectNew", "request": {"name": "P"}, "error": {"code": "already_exists", "message": "project already exists: P"}}
+`
+
+func TestReplay(t *testing.T) {
+	xs, err := ReadRecording(strings.NewReader(replayRecording))
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+	newClient := func(replay *Replay) inv1connect.ServiceClient {
+		store := NewModelStore(data.Clone(), nil)
+		s := NewStubService(store)
+		s.replay = replay
+		_, handler := inv1connect.NewServiceHandler(s, connect.WithInterceptors(&SessionInterceptor{Store: store}))
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		return inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	}
+	ctx := context.Background()
+	state := func(client inv1connect.ServiceClient) (string, error) {
+		resp, err := client.ProjectGet(ctx, connect.NewRequest(&v1.ProjectGetRequest{Project: "P"}))
+		if err != nil {
+			return "", err
+		}
+		return resp.Msg.Discs[0].ThumbState, nil
+	}
+	newProject := func(client inv1connect.ServiceClient, name string) error {
+		_, err := client.ProjectNew(ctx, connect.NewRequest(&v1.ProjectNewRequest{Name: name}))
+		return err
+	}
+
+	t.Run("any order", func(t *testing.T) {
+		replay := NewReplay(xs)
+		replay.Strict = true
+		client := newClient(replay)
+		if err := newProject(client, "P"); connect.CodeOf(err) != connect.CodeAlreadyExists {
+			t.Errorf("Expected the recorded AlreadyExists, got: %v", err)
+		}
+		for i, want := range []string{"waiting", "done", "done"} {
+			if got, err := state(client); err != nil || got != want {
+				t.Errorf("Call %d: expected %s, got %q, %v", i+1, want, got, err)
+			}
+		}
+		if got := replay.Remaining(); got != 0 {
+			t.Errorf("Expected every recorded call to be used, got %d left", got)
+		}
+		err := newProject(client, "Q")
+		if connect.CodeOf(err) != connect.CodeNotFound || !strings.Contains(err.Error(), `{"name":"Q"}`) {
+			t.Errorf("Expected NotFound for an unrecorded call, got: %v", err)
+		}
+	})
+
+	t.Run("ordered", func(t *testing.T) {
+		replay := NewReplay(xs)
+		replay.Strict = true
+		replay.Ordered = true
+		client := newClient(replay)
+		err := newProject(client, "P")
+		if connect.CodeOf(err) != connect.CodeNotFound || !strings.Contains(err.Error(), "want recorded call 1") {
+			t.Errorf("Expected NotFound for a call out of order, got: %v", err)
+		}
+		for i, want := range []string{"waiting", "done"} {
+			if got, err := state(client); err != nil || got != want {
+				t.Errorf("Call %d: expected %s, got %q, %v", i+1, want, got, err)
+			}
+		}
+		if err := newProject(client, "P"); connect.CodeOf(err) != connect.CodeAlreadyExists {
+			t.Errorf("Expected the recorded AlreadyExists, got: %v", err)
+		}
+		if _, err := state(client); connect.CodeOf(err) != connect.CodeNotFound {
+			t.Errorf("Expected NotFound after the last recorded call, got: %v", err)
+		}
+
+		replay.Reset()
+		if got, err := state(client); err != nil || got != "waiting" {
+			t.Errorf("Expected the replay to start over, got %q, %v", got, err)
+		}
+	})
+
+	t.Run("wrong response type", func(t *testing.T) {
+		replay := NewReplay([]Exchange{{
+			Procedure: inv1connect.ServiceProjectGetProcedure,
+			Request:   &v1.ProjectGetRequest{Project: "P"},
+			Response:  &v1.ProjectListResponse{},
+		}})
+		client := newClient(replay)
+		_, err := state(client)
+		if connect.CodeOf(err) != connect.CodeInternal || !strings.Contains(err.Error(), "recorded call 1") {
+			t.Errorf("Expected Internal naming the recorded call, got: %v", err)
+		}
+		if got := replay.Remaining(); got != 1 {
+			t.Errorf("Expected the recorded call to stay unused, got %d left", got)
+		}
+	})
+
+	t.Run("fall through", func(t *testing.T) {
+		client := newClient(NewReplay(xs))
+		if err := newProject(client, "Q"); err != nil {
+			t.Errorf("Expected an unrecorded call to reach the model, got: %v", err)
+		}
+		resp, err := client.ProjectList(ctx, connect.NewRequest(&v1.ProjectListRequest{}))
+		if err != nil || !strings.Contains(strings.Join(resp.Msg.Projects, ","), "Q") {
+			t.Errorf("Expected the model's projects, got %v, %v", resp, err)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"connectrpc.com/connect"
+)
+
+// SessionHeader selects an isolated model for a request, so that tests running
+// in parallel against one server do not see each other's changes.  Requests
+// without it share the base model.
+const SessionHeader = "X-Stub-Session"
+
+// ModelStore holds the base model and the per-session models.  Sessions are
+// cloned from the base fixture, the model the base started as when it was
+// last loaded or replaced, so changes made to the base model do not leak into
+// them.  Sessions live until they are discarded or the store is closed.
+type ModelStore struct {
+	clock  *VirtualClock
+	thumbs *ThumbConfig
+
+	// base is swapped as a whole when it is replaced, so a request that loads
+	// it once works against a consistent snapshot.
+	base atomic.Pointer[Model]
+
+	// fixture is an untouched copy of the base model as it was last
+	// replaced, which new sessions are cloned from.
+	fixture atomic.Pointer[Model]
+
+	mu       sync.Mutex
+	sessions map[string]*Model
+}
+
+// NewModelStore returns a ModelStore with base as its base model.  Every model
+// the store serves tells the time with the store's Clock.  If thumbs is not
+// nil, each also runs a thumbnail simulator with that configuration until it
+// is replaced or discarded.
+func NewModelStore(base *Model, thumbs *ThumbConfig) *ModelStore {
+	s := &ModelStore{
+		clock:    &VirtualClock{},
+		thumbs:   thumbs,
+		sessions: make(map[string]*Model),
+	}
+	s.fixture.Store(base.Clone())
+	s.start(base)
+	s.base.Store(base)
+	return s
+}
+
+// start prepares a model the store is about to serve.
+func (s *ModelStore) start(m *Model) {
+	m.SetClock(s.clock)
+	if s.thumbs != nil {
+		m.StartThumbs(*s.thumbs)
+	}
+}
+
+// Clock returns the clock shared by all of the store's models.
+func (s *ModelStore) Clock() *VirtualClock {
+	return s.clock
+}
+
+// Tick applies the thumbnail transitions due in every model in the store.
+func (s *ModelStore) Tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Base().Tick()
+	for _, m := range s.sessions {
+		m.Tick()
+	}
+}
+
+// Base returns the base model.
+func (s *ModelStore) Base() *Model {
+	return s.base.Load()
+}
+
+// Session returns the model for the named session, cloning it from the base
+// fixture on first use.  The empty session is the base model.
+func (s *ModelStore) Session(id string) *Model {
+	if id == "" {
+		return s.Base()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.sessions[id]
+	if !ok {
+		m = s.fixture.Load().Clone()
+		s.start(m)
+		s.sessions[id] = m
+	}
+	return m
+}
+
+// Replace swaps in m as the model for the named session, or as the base model
+// and base fixture if id is empty.  Existing sessions keep the model they were
+// cloned from.
+func (s *ModelStore) Replace(id string, m *Model) {
+	if id == "" {
+		s.fixture.Store(m.Clone())
+	}
+	s.start(m)
+	var old *Model
+	if id == "" {
+		old = s.base.Swap(m)
+	} else {
+		s.mu.Lock()
+		old = s.sessions[id]
+		s.sessions[id] = m
+		s.mu.Unlock()
+	}
+	if old != nil && old != m {
+		old.Close()
+	}
+}
+
+// Peek returns the model for the named session without creating it: the
+// session's model if it exists, or else a copy of the base fixture it would
+// start from.  The empty session is the base model.
+func (s *ModelStore) Peek(id string) *Model {
+	if id == "" {
+		return s.Base()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.sessions[id]; ok {
+		return m
+	}
+	return s.fixture.Load().Clone()
+}
+
+// Discard forgets the named session.  Reports whether it existed.
+func (s *ModelStore) Discard(id string) bool {
+	s.mu.Lock()
+	m, ok := s.sessions[id]
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	if ok {
+		m.Close()
+	}
+	return ok
+}
+
+// Close stops the thumbnail simulators of every model in the store.
+func (s *ModelStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.sessions {
+		m.Close()
+	}
+	s.Base().Close()
+}
+
+// SessionIDs returns the ids of all live sessions, sorted.
+func (s *ModelStore) SessionIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.sessions))
+}
+
+type modelKey struct{}
+
+// withModel returns a copy of ctx carrying m as the model for the request.
+func withModel(ctx context.Context, m *Model) context.Context {
+	return context.WithValue(ctx, modelKey{}, m)
+}
+
+// modelFromContext returns the model stored by withModel, or nil.
+func modelFromContext(ctx context.Context) *Model {
+	m, _ := ctx.Value(modelKey{}).(*Model)
+	return m
+}
+
+// SessionInterceptor implements connect.Interceptor to resolve the model for
+// each RPC from its SessionHeader and store it in the request context.
+type SessionInterceptor struct {
+	Store *ModelStore
+}
+
+// WrapUnary implements the Interceptor interface for unary RPC calls
+func (i *SessionInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		m := i.Store.Session(req.Header().Get(SessionHeader))
+		return next(withModel(ctx, m), req)
+	}
+}
+
+// WrapStreamingClient implements the Interceptor interface for streaming client calls
+func (i *SessionInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next // No streaming clients in this stub service
+}
+
+// WrapStreamingHandler implements the Interceptor interface for streaming handler calls
+func (i *SessionInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next // No streaming handlers in this stub service
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"buf.build/gen/go/krelinga/proto/connectrpc/go/krelinga/video/in/v1/inv1connect"
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"connectrpc.com/connect"
+)
+
+func TestSessions(t *testing.T) {
+	store := NewModelStore(&Model{Projects: []*v1.ProjectGetResponse{{Project: "Base"}}}, nil)
+	_, handler := inv1connect.NewServiceHandler(
+		NewStubService(store),
+		connect.WithInterceptors(&SessionInterceptor{Store: store}),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := inv1connect.NewServiceClient(http.DefaultClient, server.URL)
+	ctx := context.Background()
+
+	newProject := func(session, name string) {
+		t.Helper()
+		req := connect.NewRequest(&v1.ProjectNewRequest{Name: name})
+		if session != "" {
+			req.Header().Set(SessionHeader, session)
+		}
+		if _, err := client.ProjectNew(ctx, req); err != nil {
+			t.Fatalf("ProjectNew call failed: %v", err)
+		}
+	}
+	projects := func(session string) []string {
+		t.Helper()
+		req := connect.NewRequest(&v1.ProjectListRequest{})
+		if session != "" {
+			req.Header().Set(SessionHeader, session)
+		}
+		resp, err := client.ProjectList(ctx, req)
+		if err != nil {
+			t.Fatalf("ProjectList call failed: %v", err)
+		}
+		return resp.Msg.Projects
+	}
+
+	newProject("a", "Only A")
+	newProject("b", "Only B")
+
+	if got, want := projects("a"), []string{"Base", "Only A"}; !slices.Equal(got, want) {
+		t.Errorf("Expected session a projects %v, got %v", want, got)
+	}
+	if got, want := projects("b"), []string{"Base", "Only B"}; !slices.Equal(got, want) {
+		t.Errorf("Expected session b projects %v, got %v", want, got)
+	}
+	if got, want := projects(""), []string{"Base"}; !slices.Equal(got, want) {
+		t.Errorf("Expected base projects %v, got %v", want, got)
+	}
+	if got, want := store.SessionIDs(), []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("Expected sessions %v, got %v", want, got)
+	}
+
+	// A discarded session starts over from the base model.
+	if !store.Discard("a") {
+		t.Fatal("Expected session a to exist")
+	}
+	if got, want := projects("a"), []string{"Base"}; !slices.Equal(got, want) {
+		t.Errorf("Expected fresh session a projects %v, got %v", want, got)
+	}
+
+	// Changes to the base model do not leak into new sessions.
+	newProject("", "Only Base")
+	if got, want := projects("c"), []string{"Base"}; !slices.Equal(got, want) {
+		t.Errorf("Expected new session c projects %v, got %v", want, got)
+	}
+
+	// Until the base model is replaced.
+	store.Replace("", &Model{Projects: []*v1.ProjectGetResponse{{Project: "Replaced"}}})
+	if got, want := projects("d"), []string{"Replaced"}; !slices.Equal(got, want) {
+		t.Errorf("Expected new session d projects %v, got %v", want, got)
+	}
+
+	// Peeking at a session does not create it.
+	if got := store.Peek("e").Projects; len(got) != 1 || got[0].Project != "Replaced" {
+		t.Errorf("Expected to peek at the base fixture, got %v", got)
+	}
+	if got, want := store.SessionIDs(), []string{"a", "b", "c", "d"}; !slices.Equal(got, want) {
+		t.Errorf("Expected sessions %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// templateData is what response templates can refer to.
+type templateData struct {
+	Request map[string]any // the request in protojson form, with every field present
+	Seq     int            // how many calls the mapping has answered, counting this one
+	Now     time.Time      // the stub's clock
+}
+
+// newTemplateData returns the data for rendering the response to the seq'th
+// call with request req answered by a mapping.  Request fields are named as
+// in fixtures, in camelCase.
+func newTemplateData(req proto.Message, seq int, now time.Time) (templateData, error) {
+	data := templateData{Seq: seq, Now: now}
+	b, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(req)
+	if err != nil {
+		return data, err
+	}
+	err = json.Unmarshal(b, &data.Request)
+	return data, err
+}
+
+// renderResponse returns a copy of resp in which every string field containing
+// "{{" has been executed as a text/template.  For example:
+//
+//	message: "Hello, {{.Request.name}}! You are visitor {{.Seq}}."
+//	releaseDate: "{{.Now.Format \"2006-01-02\"}}"
+func renderResponse(resp proto.Message, data templateData) (proto.Message, error) {
+	out := proto.Clone(resp)
+	if err := renderMessage(out.ProtoReflect(), data, "response"); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// renderMessage executes the templates in the string fields of msg in place.
+// Errors name the field as path.field.
+func renderMessage(msg protoreflect.Message, data templateData, path string) error {
+	var err error
+	walkStrings(msg, path, func(field, s string) string {
+		if err != nil {
+			return s
+		}
+		var out string
+		out, err = renderString(field, s, data)
+		return out
+	})
+	return err
+}
+
+// renderString executes s as a template named name, which appears in errors.
+// Strings without "{{" are returned as they are.
+func renderString(name, s string, data templateData) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	t, err := template.New(name).Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// walkStrings replaces every populated string value in msg, including those in
+// repeated fields, map values and nested messages, with f of its path and
+// value.
+func walkStrings(msg protoreflect.Message, path string, f func(field, s string) string) {
+	// Collect the fields first, since msg is modified along the way.
+	var fields []protoreflect.FieldDescriptor
+	msg.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields = append(fields, fd)
+		return true
+	})
+	for _, fd := range fields {
+		v := msg.Get(fd)
+		field := path + "." + fd.JSONName()
+		if path == "" {
+			field = fd.JSONName()
+		}
+		switch {
+		case fd.IsList():
+			list := v.List()
+			for i := range list.Len() {
+				elem := fmt.Sprintf("%s[%d]", field, i)
+				switch {
+				case fd.Kind() == protoreflect.StringKind:
+					list.Set(i, protoreflect.ValueOfString(f(elem, list.Get(i).String())))
+				case fd.Message() != nil:
+					walkStrings(list.Get(i).Message(), elem, f)
+				}
+			}
+		case fd.IsMap():
+			vd := fd.MapValue()
+			var keys []protoreflect.MapKey
+			v.Map().Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+				keys = append(keys, k)
+				return true
+			})
+			for _, k := range keys {
+				elem := fmt.Sprintf("%s[%s]", field, k.String())
+				switch {
+				case vd.Kind() == protoreflect.StringKind:
+					v.Map().Set(k, protoreflect.ValueOfString(f(elem, v.Map().Get(k).String())))
+				case vd.Message() != nil:
+					walkStrings(v.Map().Get(k).Message(), elem, f)
+				}
+			}
+		case fd.Kind() == protoreflect.StringKind:
+			msg.Set(fd, protoreflect.ValueOfString(f(field, v.String())))
+		case fd.Message() != nil:
+			walkStrings(v.Message(), field, f)
+		}
+	}
+}
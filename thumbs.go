@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// thumbTick is how often a running simulator checks for due transitions.
+const thumbTick = 100 * time.Millisecond
+
+// ThumbConfig controls the simulated thumbnail pipeline, which moves discs
+// assigned through ProjectAssignDiskDirs from waiting to working to done (or
+// error).  Discs loaded from a fixture keep whatever state they were given.
+type ThumbConfig struct {
+	WaitDelay time.Duration // time a disc spends waiting before work starts
+	WorkDelay time.Duration // time a disc spends working before it finishes
+	ErrorRate float64       // probability that a disc ends in error instead of done
+	Seed      uint64        // seed for the error decisions
+}
+
+// thumbTemplate is copied into the DiscFiles of every disc whose simulated
+// thumbnails finish successfully.
+var thumbTemplate = []*v1.DiscFile{
+	{File: "title_t00.mkv", Thumb: "title_t00.jpg", HumanSize: "4.2 GB", HumanDuration: "01:52:10", NumChapters: 24},
+	{File: "title_t01.mkv", Thumb: "title_t01.jpg", HumanSize: "650 MB", HumanDuration: "00:21:35", NumChapters: 4},
+	{File: "title_t02.mkv", Thumb: "title_t02.jpg", HumanSize: "120 MB", HumanDuration: "00:03:12", NumChapters: 1},
+}
+
+// thumbJob tracks a disc whose thumbnails are being simulated.
+type thumbJob struct {
+	project string
+	disc    string
+	since   time.Time // when the disc entered its current state
+}
+
+// thumbSimulator is the state of a model's running simulator goroutine.
+type thumbSimulator struct {
+	cfg  ThumbConfig
+	rng  *rand.Rand
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartThumbs starts a goroutine that advances the model's thumbnail jobs
+// according to cfg, until Close is called.  Does nothing if the simulator is
+// already running.
+func (m *Model) StartThumbs(cfg ThumbConfig) {
+	sim := &thumbSimulator{
+		cfg:  cfg,
+		rng:  rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	m.mu.Lock()
+	if m.thumbs != nil {
+		m.mu.Unlock()
+		return
+	}
+	m.thumbs = sim
+	m.mu.Unlock()
+
+	go func() {
+		defer close(sim.done)
+		ticker := time.NewTicker(thumbTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sim.stop:
+				return
+			case <-ticker.C:
+				m.Tick()
+			}
+		}
+	}()
+}
+
+// Close stops the model's thumbnail simulator, if it has one, and waits for
+// its goroutine to exit.
+func (m *Model) Close() {
+	m.mu.Lock()
+	sim := m.thumbs
+	m.thumbs = nil
+	m.mu.Unlock()
+	if sim != nil {
+		close(sim.stop)
+		<-sim.done
+	}
+}
+
+// Tick applies every thumbnail transition due by the model's clock.  The
+// simulator calls it periodically; call it directly to see the effect of moving
+// a VirtualClock without waiting for the next tick.
+func (m *Model) Tick() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.advanceThumbs(m.now())
+}
+
+// advanceThumbs applies every transition due by now.  Jobs are processed in
+// the order their discs were assigned, so that runs with the same seed make
+// the same error decisions.  Callers must hold m.mu.
+func (m *Model) advanceThumbs(now time.Time) {
+	if m.thumbs == nil {
+		return
+	}
+	pending := m.thumbJobs[:0]
+	for _, j := range m.thumbJobs {
+		if m.advanceThumbJob(&j, now) {
+			pending = append(pending, j)
+		}
+	}
+	m.thumbJobs = pending
+}
+
+// advanceThumbJob applies the transitions of j due by now, and reports
+// whether j has any left.  Callers must hold m.mu.
+func (m *Model) advanceThumbJob(j *thumbJob, now time.Time) bool {
+	p := m.FindProject(j.project)
+	if p == nil {
+		return false
+	}
+	d := findDisc(p, j.disc)
+	if d == nil {
+		return false
+	}
+	cfg := m.thumbs.cfg
+	for {
+		switch d.ThumbState {
+		case ThumbStateWaiting:
+			due := j.since.Add(cfg.WaitDelay)
+			if now.Before(due) {
+				return true
+			}
+			d.ThumbState = ThumbStateWorking
+			j.since = due
+		case ThumbStateWorking:
+			if now.Before(j.since.Add(cfg.WorkDelay)) {
+				return true
+			}
+			if m.thumbs.rng.Float64() < cfg.ErrorRate {
+				d.ThumbState = ThumbStateError
+				return false
+			}
+			d.ThumbState = ThumbStateDone
+			d.DiscFiles = nil
+			for _, f := range thumbTemplate {
+				d.DiscFiles = append(d.DiscFiles, proto.Clone(f).(*v1.DiscFile))
+			}
+			return false
+		default:
+			// The state was changed some other way, e.g. through the admin API.
+			return false
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	v1 "buf.build/gen/go/krelinga/proto/protocolbuffers/go/krelinga/video/in/v1"
+)
+
+// newThumbModel returns a model whose simulator never fires on its own, with
+// dirs assigned to project "P".  The returned time is when they were assigned.
+func newThumbModel(t *testing.T, cfg ThumbConfig, dirs ...string) (*Model, time.Time) {
+	t.Helper()
+	m := &Model{
+		Projects:  []*v1.ProjectGetResponse{{Project: "P"}},
+		Unclaimed: slices.Clone(dirs),
+	}
+	m.StartThumbs(cfg)
+	t.Cleanup(m.Close)
+	if err := m.AssignDiscDirs("P", dirs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m, m.thumbJobs[0].since
+}
+
+func advance(m *Model, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.advanceThumbs(now)
+}
+
+func thumbStates(m *Model) []string {
+	var states []string
+	for _, d := range m.GetProject("P").Discs {
+		states = append(states, d.ThumbState)
+	}
+	return states
+}
+
+func TestThumbTransitions(t *testing.T) {
+	cfg := ThumbConfig{WaitDelay: time.Hour, WorkDelay: 2 * time.Hour}
+	m, start := newThumbModel(t, cfg, "Disc")
+
+	steps := []struct {
+		at   time.Duration
+		want string
+	}{
+		{at: 0, want: ThumbStateWaiting},
+		{at: time.Hour - time.Second, want: ThumbStateWaiting},
+		{at: time.Hour, want: ThumbStateWorking},
+		{at: 3*time.Hour - time.Second, want: ThumbStateWorking},
+		{at: 3 * time.Hour, want: ThumbStateDone},
+	}
+	for _, step := range steps {
+		advance(m, start.Add(step.at))
+		if got := thumbStates(m); !slices.Equal(got, []string{step.want}) {
+			t.Fatalf("At %v: expected states [%s], got %v", step.at, step.want, got)
+		}
+	}
+
+	disc := m.GetProject("P").Discs[0]
+	if len(disc.DiscFiles) != len(thumbTemplate) {
+		t.Fatalf("Expected %d disc files, got %d", len(thumbTemplate), len(disc.DiscFiles))
+	}
+	if disc.DiscFiles[0].File != thumbTemplate[0].File {
+		t.Errorf("Expected file %s, got %s", thumbTemplate[0].File, disc.DiscFiles[0].File)
+	}
+	if len(m.thumbJobs) != 0 {
+		t.Errorf("Expected no pending jobs, got %d", len(m.thumbJobs))
+	}
+
+	// A late tick applies every transition that is due at once.
+	m, start = newThumbModel(t, cfg, "Late")
+	advance(m, start.Add(24*time.Hour))
+	if got, want := thumbStates(m), []string{ThumbStateDone}; !slices.Equal(got, want) {
+		t.Errorf("Expected states %v, got %v", want, got)
+	}
+}
+
+func TestThumbSeed(t *testing.T) {
+	dirs := []string{"D1", "D2", "D3", "D4", "D5", "D6", "D7", "D8"}
+	run := func(seed uint64) []string {
+		cfg := ThumbConfig{WaitDelay: time.Hour, WorkDelay: time.Hour, ErrorRate: 0.5, Seed: seed}
+		m, start := newThumbModel(t, cfg, dirs...)
+		advance(m, start.Add(2*time.Hour))
+		return thumbStates(m)
+	}
+
+	first := run(42)
+	if got := run(42); !slices.Equal(got, first) {
+		t.Errorf("Expected the same seed to give %v, got %v", first, got)
+	}
+	if !slices.Contains(first, ThumbStateError) || !slices.Contains(first, ThumbStateDone) {
+		t.Errorf("Expected a mix of error and done with ErrorRate 0.5, got %v", first)
+	}
+}